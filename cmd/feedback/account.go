@@ -0,0 +1,286 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func (a *App) handleMySettings(w http.ResponseWriter, r *http.Request) {
+	sess := a.readSession(r)
+	if sess.UID == "" {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	user, err := a.store.UserByID(ctx, sess.UID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		a.serverError(w, r, "查询失败", err)
+		return
+	}
+	if err != nil || user.Deleted() {
+		a.clearSession(w)
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	flash := ""
+	if r.URL.Query().Get("bad") == "1" {
+		flash = "确认失败：请输入你的用户名。"
+	}
+	if r.URL.Query().Get("bad_tz") == "1" {
+		flash = "时区无效：请填写 IANA 时区名，比如 Asia/Shanghai。"
+	}
+	tzChoice := ""
+	if name, _, ok := tzFromCookie(r, tzCookieName); ok {
+		tzChoice = name
+	}
+
+	a.render(w, r, "me_settings.html", ViewData{
+		Title:           "账号设置",
+		Session:         sess,
+		User:            user,
+		IsAuthed:        true,
+		FlashError:      flash,
+		DeletePolicy:    a.cfg.AccountDeletePolicy,
+		DeleteGraceDays: int(a.cfg.AccountDeleteGrace / (24 * time.Hour)),
+		DeleteDueAt:     deletionDueAt(user, a.cfg.AccountDeleteGrace),
+		TZChoice:        tzChoice,
+		Timezones:       commonTimezones,
+	})
+}
+
+// deletionDueAt 返回注销实际执行的时间，没有申请时为零值。
+func deletionDueAt(u *User, grace time.Duration) time.Time {
+	if u == nil || u.DeletionRequestedAt.IsZero() {
+		return time.Time{}
+	}
+	return u.DeletionRequestedAt.Add(grace)
+}
+
+// handleMyExport 把当前用户的资料、反馈（含私有）、相关回复和自己提交的举报打成一个 ZIP 下载。
+// 资料、反馈、回复的记录格式和管理员的 JSONL 导出一致，但不含只给管理员看的审核备注。
+func (a *App) handleMyExport(w http.ResponseWriter, r *http.Request) {
+	sess := a.readSession(r)
+	if sess.UID == "" {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	user, err := a.store.UserByID(ctx, sess.UID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		a.serverError(w, r, "查询失败", err)
+		return
+	}
+	if err != nil || user.Deleted() {
+		http.NotFound(w, r)
+		return
+	}
+	feedback, err := a.store.ListFeedback(ctx, FeedbackQuery{UserID: sess.UID, Oldest: true, Limit: -1})
+	if err != nil {
+		a.serverError(w, r, "查询失败", err)
+		return
+	}
+	// 回复包括：别人在我的反馈下的回复，以及我自己写的回复（管理员账号才会有）。
+	seen := map[string]bool{}
+	replies := []exportRecord{}
+	addReplies := func(list []Reply) {
+		for _, it := range list {
+			if !seen[it.ID] {
+				seen[it.ID] = true
+				replies = append(replies, replyRecord(it))
+			}
+		}
+	}
+	for _, f := range feedback {
+		list, err := a.store.RepliesByFeedbackID(ctx, f.ID)
+		if err != nil {
+			a.serverError(w, r, "查询失败", err)
+			return
+		}
+		addReplies(list)
+	}
+	own, err := a.store.RepliesByUserID(ctx, sess.UID)
+	if err != nil {
+		a.serverError(w, r, "查询失败", err)
+		return
+	}
+	addReplies(own)
+	reports, err := a.reportsByUser(ctx, sess.UID)
+	if err != nil {
+		a.serverError(w, r, "查询失败", err)
+		return
+	}
+
+	feedbackRecs := make([]exportRecord, 0, len(feedback))
+	for _, f := range feedback {
+		rec := feedbackRecord(f)
+		rec.ModerationNote = ""
+		feedbackRecs = append(feedbackRecs, rec)
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="feedback-my-data-`+time.Now().Format("20060102")+`.zip"`)
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name string
+		v    any
+	}{
+		{"profile.json", userRecord(*user)},
+		{"feedback.json", feedbackRecs},
+		{"replies.json", replies},
+		{"reports.json", reports},
+	}
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			slog.WarnContext(ctx, "写出个人数据失败", "file", f.name, "err", err)
+			return
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.v); err != nil {
+			slog.WarnContext(ctx, "写出个人数据失败", "file", f.name, "err", err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		slog.WarnContext(ctx, "写出个人数据失败", "err", err)
+	}
+}
+
+// handleMyDelete 提交注销申请。需要输入用户名确认；宽限期为 0 时立即执行。
+func (a *App) handleMyDelete(w http.ResponseWriter, r *http.Request) {
+	sess := a.readSession(r)
+	if sess.UID == "" {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		a.renderError(w, r, http.StatusBadRequest, "表单解析失败")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	user, err := a.store.UserByID(ctx, sess.UID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		a.serverError(w, r, "查询失败", err)
+		return
+	}
+	if err != nil || user.Deleted() {
+		http.NotFound(w, r)
+		return
+	}
+	if strings.TrimSpace(r.FormValue("confirm")) != user.Username {
+		http.Redirect(w, r, "/me/settings?bad=1", http.StatusFound)
+		return
+	}
+
+	if a.cfg.AccountDeleteGrace <= 0 {
+		if err := a.deleteAccount(ctx, user.ID); err != nil {
+			a.serverError(w, r, "注销失败", err)
+			return
+		}
+		a.clearSession(w)
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	if err := a.store.SetUserDeletionRequest(ctx, user.ID, time.Now()); err != nil {
+		a.serverError(w, r, "写入失败", err)
+		return
+	}
+	http.Redirect(w, r, "/me/settings", http.StatusFound)
+}
+
+func (a *App) handleMyDeleteCancel(w http.ResponseWriter, r *http.Request) {
+	sess := a.readSession(r)
+	if sess.UID == "" {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := a.store.SetUserDeletionRequest(ctx, sess.UID, time.Time{}); err != nil {
+		a.serverError(w, r, "写入失败", err)
+		return
+	}
+	http.Redirect(w, r, "/me/settings", http.StatusFound)
+}
+
+func (a *App) deleteAccount(ctx context.Context, id string) error {
+	return a.store.DeleteUserData(ctx, id, a.cfg.AccountDeletePolicy == deletePolicyDelete)
+}
+
+// accountGone 判断会话里的用户是不是已经注销了。会话 Cookie 没法主动作废，
+// 所以写操作前要查一下，免得注销后旧 Cookie 还能以「已注销用户」的身份发内容。
+func (a *App) accountGone(ctx context.Context, uid string) bool {
+	u, err := a.store.UserByID(ctx, uid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true
+	}
+	if err != nil {
+		// 查不到就放行，后面的写入本身也会报错。
+		slog.ErrorContext(ctx, "查询当前用户失败", "user", uid, "err", err)
+		return false
+	}
+	return u.Deleted()
+}
+
+// sessionUser 查会话对应的用户，给页面头部显示头像和用户名用。没登录或用户已不存在时返回 nil；
+// 查询出错时记日志、同样返回 nil，页面按未取到用户信息照常渲染。
+func (a *App) sessionUser(ctx context.Context, sess Session) *User {
+	if sess.UID == "" {
+		return nil
+	}
+	u, err := a.store.UserByID(ctx, sess.UID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			slog.ErrorContext(ctx, "查询当前用户失败", "user", sess.UID, "err", err)
+		}
+		return nil
+	}
+	return u
+}
+
+// runAccountDeletions 定期执行过了宽限期的注销申请。
+func (a *App) runAccountDeletions(ctx context.Context) {
+	t := time.NewTicker(time.Hour)
+	defer t.Stop()
+	for {
+		dctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+		due, err := a.store.UsersDueForDeletion(dctx, time.Now().Add(-a.cfg.AccountDeleteGrace))
+		if err != nil {
+			slog.Error("查询待注销账号失败", "err", err)
+		}
+		for _, u := range due {
+			if err := a.deleteAccount(dctx, u.ID); err != nil {
+				slog.Error("注销账号失败", "user", u.ID, "err", err)
+				continue
+			}
+			slog.Info("已注销账号", "user", u.ID, "policy", a.cfg.AccountDeletePolicy)
+		}
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -1,25 +1,67 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"html/template"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 type App struct {
-	cfg Config
-	db  *sql.DB
+	cfg   Config
+	db    *DB
+	store Store
 
-	tpl *template.Template
+	tpls    map[string]*template.Template // 按语言
+	mdCache *htmlCache                    // 为 nil 时不缓存
+
+	health healthState
 }
 
 func (a *App) withMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// 一些很基础的安全 Header，避免误伤，不搞花里胡哨。
-		w.Header().Set("X-Content-Type-Options", "nosniff")
-		w.Header().Set("X-Frame-Options", "DENY")
-		w.Header().Set("Referrer-Policy", "same-origin")
+		nonce := newNonce()
+		if m := metaFrom(r.Context()); m != nil {
+			m.nonce = nonce
+		} else {
+			r = r.WithContext(context.WithValue(r.Context(), requestMetaKey, &requestMeta{nonce: nonce}))
+		}
+		a.setSecurityHeaders(w, nonce)
+		// 只在 HTTPS 上发 HSTS（直接 TLS，或者反代终止 TLS 且对外地址是 https），明文响应里的 HSTS 浏览器本来也会忽略。
+		if a.cfg.HSTSMaxAgeDays > 0 && (r.TLS != nil || a.cfg.AppBaseURLHasTLS()) {
+			v := "max-age=" + strconv.Itoa(a.cfg.HSTSMaxAgeDays*24*3600)
+			if a.cfg.HSTSIncludeSubdomains {
+				v += "; includeSubDomains"
+			}
+			w.Header().Set("Strict-Transport-Security", v)
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
+// clientIP 取请求方 IP。服务默认只监听 127.0.0.1、前面挂反代，
+// 所以只有来自本机的请求才信任 X-Forwarded-For / X-Real-IP。
+// X-Forwarded-For 取最右边一项：那是反代自己追加的，左边的可能是客户端随便填的。
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+			last := xff[len(xff)-1]
+			if i := strings.LastIndex(last, ","); i >= 0 {
+				last = last[i+1:]
+			}
+			if v := strings.TrimSpace(last); net.ParseIP(v) != nil {
+				return v
+			}
+		}
+		if v := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(v) != nil {
+			return v
+		}
+	}
+	return host
+}
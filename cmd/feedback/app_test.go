@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	cases := []struct {
+		name, remote string
+		xff          []string
+		realIP, want string
+	}{
+		{"direct", "203.0.113.9:5000", []string{"1.2.3.4"}, "", "203.0.113.9"},
+		{"proxy", "127.0.0.1:5000", []string{"198.51.100.7"}, "", "198.51.100.7"},
+		{"forged left entry", "127.0.0.1:5000", []string{"1.2.3.4, 198.51.100.7"}, "", "198.51.100.7"},
+		{"two headers", "127.0.0.1:5000", []string{"1.2.3.4", "198.51.100.7"}, "", "198.51.100.7"},
+		{"real ip", "[::1]:5000", nil, "198.51.100.8", "198.51.100.8"},
+		{"garbage", "127.0.0.1:5000", []string{"not-an-ip"}, "", "127.0.0.1"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = c.remote
+		for _, v := range c.xff {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if c.realIP != "" {
+			r.Header.Set("X-Real-IP", c.realIP)
+		}
+		if got := clientIP(r); got != c.want {
+			t.Errorf("%s: clientIP = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 审计动作名。统一放这里，页面上的筛选下拉框也用这份列表。
+const (
+	auditAdminLogin       = "admin.login"
+	auditAdminLoginFailed = "admin.login_failed"
+	auditReplyCreate      = "reply.create"
+	auditBlocklistAdd     = "blocklist.add"
+	auditBlocklistDelete  = "blocklist.delete"
+	auditFeedbackApprove  = "feedback.approve"
+	auditFeedbackReject   = "feedback.reject"
+	auditFeedbackMerge    = "feedback.merge"
+	auditFeedbackUnmerge  = "feedback.unmerge"
+	auditReportAutoHide   = "report.auto_hide"
+	auditReportDismiss    = "report.dismiss"
+	auditReportHide       = "report.hide"
+	auditDataExport       = "data.export"
+	auditDataImport       = "data.import"
+	auditTemplateCreate   = "reply_template.create"
+	auditTemplateUpdate   = "reply_template.update"
+	auditTemplateDelete   = "reply_template.delete"
+)
+
+var auditActions = []string{
+	auditAdminLogin,
+	auditAdminLoginFailed,
+	auditReplyCreate,
+	auditBlocklistAdd,
+	auditBlocklistDelete,
+	auditFeedbackApprove,
+	auditFeedbackReject,
+	auditFeedbackMerge,
+	auditFeedbackUnmerge,
+	auditReportAutoHide,
+	auditReportDismiss,
+	auditReportHide,
+	auditDataExport,
+	auditDataImport,
+	auditTemplateCreate,
+	auditTemplateUpdate,
+	auditTemplateDelete,
+}
+
+type AuditEntry struct {
+	ID            string          `json:"id"`
+	CreatedAt     time.Time       `json:"created_at"`
+	ActorUserID   string          `json:"actor_user_id,omitempty"`
+	ActorUsername string          `json:"actor_username,omitempty"`
+	ActorIP       string          `json:"actor_ip"`
+	Action        string          `json:"action"`
+	TargetType    string          `json:"target_type,omitempty"`
+	TargetID      string          `json:"target_id,omitempty"`
+	Before        json.RawMessage `json:"before,omitempty"`
+	After         json.RawMessage `json:"after,omitempty"`
+}
+
+type AuditFilter struct {
+	Action string
+	Actor  string
+	Target string
+	From   string // YYYY-MM-DD
+	To     string // YYYY-MM-DD
+
+	Author string // 只看针对这个用户（ID）所写反馈的操作，用户主页用
+}
+
+// audit 记录一条管理操作。before/after 是操作前后的快照，可以为 nil。
+// 写审计失败不影响主流程，只打日志。
+// 系统自动做的操作传 r = nil、sess 为零值：不记 IP，操作人为空，页面上显示为「系统」。
+func (a *App) audit(ctx context.Context, r *http.Request, sess Session, action, targetType, targetID string, before, after any) {
+	ip := ""
+	if r != nil {
+		ip = clientIP(r)
+	}
+	_, err := a.db.ExecContext(ctx, `
+		INSERT INTO audit_logs(id, created_at, actor_user_id, actor_ip, action, target_type, target_id, before_json, after_json)
+		VALUES(?,?,?,?,?,?,?,?,?)
+	`, newID(), time.Now().Unix(), nullIfEmpty(sess.UID), ip, action, targetType, targetID, auditJSON(before), auditJSON(after))
+	if err != nil {
+		slog.ErrorContext(ctx, "写审计日志失败", "action", action, "target", targetType+"/"+targetID, "err", err)
+	}
+}
+
+func auditJSON(v any) any {
+	if v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return string(b)
+}
+
+func auditFilterFromRequest(r *http.Request) AuditFilter {
+	q := r.URL.Query()
+	return AuditFilter{
+		Action: strings.TrimSpace(q.Get("action")),
+		Actor:  strings.TrimSpace(q.Get("actor")),
+		Target: strings.TrimSpace(q.Get("target")),
+		From:   strings.TrimSpace(q.Get("from")),
+		To:     strings.TrimSpace(q.Get("to")),
+	}
+}
+
+func (a *App) queryAudit(ctx context.Context, f AuditFilter, limit int) ([]AuditEntry, error) {
+	where := `WHERE 1 = 1`
+	args := []any{}
+	if f.Action != "" {
+		where += ` AND l.action = ?`
+		args = append(args, f.Action)
+	}
+	if f.Actor != "" {
+		// 操作人既可以按用户 ID 也可以按用户名筛
+		where += ` AND (l.actor_user_id = ? OR u.username = ?)`
+		args = append(args, f.Actor, f.Actor)
+	}
+	if f.Target != "" {
+		where += ` AND l.target_id = ?`
+		args = append(args, f.Target)
+	}
+	if f.Author != "" {
+		where += ` AND l.target_type = 'feedback' AND l.target_id IN (SELECT id FROM feedbacks WHERE user_id = ?)`
+		args = append(args, f.Author)
+	}
+	if t, err := time.ParseInLocation("2006-01-02", f.From, time.Local); err == nil {
+		where += ` AND l.created_at >= ?`
+		args = append(args, t.Unix())
+	}
+	if t, err := time.ParseInLocation("2006-01-02", f.To, time.Local); err == nil {
+		where += ` AND l.created_at < ?`
+		args = append(args, t.AddDate(0, 0, 1).Unix())
+	}
+	args = append(args, limit)
+
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT l.id, l.created_at, COALESCE(l.actor_user_id, ''), COALESCE(u.username, ''), l.actor_ip,
+			l.action, l.target_type, l.target_id, l.before_json, l.after_json
+		FROM audit_logs l
+		LEFT JOIN users u ON u.id = l.actor_user_id
+	`+where+`
+		ORDER BY l.created_at DESC, l.id DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		var created int64
+		var before, after sql.NullString
+		if err := rows.Scan(&e.ID, &created, &e.ActorUserID, &e.ActorUsername, &e.ActorIP,
+			&e.Action, &e.TargetType, &e.TargetID, &before, &after); err != nil {
+			slog.ErrorContext(ctx, "读取审计日志行失败", "err", err)
+			continue
+		}
+		e.CreatedAt = time.Unix(created, 0)
+		if before.Valid {
+			e.Before = json.RawMessage(before.String)
+		}
+		if after.Valid {
+			e.After = json.RawMessage(after.String)
+		}
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
+
+func (a *App) handleAdminAudit(w http.ResponseWriter, r *http.Request) {
+	sess := a.readSession(r)
+	if !sess.IsAdmin {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	user := a.sessionUser(ctx, sess)
+
+	filter := auditFilterFromRequest(r)
+	list, err := a.queryAudit(ctx, filter, 200)
+	if err != nil {
+		a.serverError(w, r, "查询失败", err)
+		return
+	}
+
+	a.render(w, r, "admin_audit.html", ViewData{
+		Title:        "审计日志",
+		Session:      sess,
+		User:         user,
+		IsAuthed:     sess.UID != "",
+		Audit:        list,
+		AuditFilter:  filter,
+		AuditActions: auditActions,
+	})
+}
+
+func (a *App) handleAdminAuditExport(w http.ResponseWriter, r *http.Request) {
+	sess := a.readSession(r)
+	if !sess.IsAdmin {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	list, err := a.queryAudit(ctx, auditFilterFromRequest(r), 100000)
+	if err != nil {
+		slog.ErrorContext(ctx, "查询失败", "err", err)
+		http.Error(w, "查询失败", http.StatusInternalServerError)
+		return
+	}
+	if list == nil {
+		list = []AuditEntry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit-`+time.Now().Format("20060102-150405")+`.json"`)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(list); err != nil {
+		slog.WarnContext(r.Context(), "写出审计导出失败", "err", err)
+	}
+}
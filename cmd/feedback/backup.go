@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupSQLite 用 VACUUM INTO 在线生成一份一致的快照，写完再做一次完整性检查。
+// WAL 模式下直接复制 data.db 会漏掉还没 checkpoint 的数据，所以不能用 cp。
+// VACUUM INTO 在只读连接上也能跑，传只读池进来就不会挡住写入。
+func backupSQLite(ctx context.Context, db *sql.DB, dest string) error {
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("目标文件已存在: %s", dest)
+	}
+	if dir := filepath.Dir(dest); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	if _, err := db.ExecContext(ctx, `VACUUM INTO ?`, dest); err != nil {
+		return fmt.Errorf("VACUUM INTO 失败: %w", err)
+	}
+	if err := checkSQLiteFile(ctx, dest); err != nil {
+		_ = os.Remove(dest)
+		return err
+	}
+	return nil
+}
+
+// checkSQLiteFile 以只读方式打开一个 SQLite 文件并跑 PRAGMA integrity_check。
+func checkSQLiteFile(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?mode=ro", path))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, `PRAGMA integrity_check`)
+	if err != nil {
+		return fmt.Errorf("完整性检查失败: %w", err)
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return err
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("完整性检查未通过: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// restoreSQLite 用 src 覆盖 dest。src 先过完整性检查；dest 原有的数据先备份到旁边，
+// 然后通过临时文件 + rename 替换，并删掉旧的 -wal / -shm，避免它们被套到新库上。
+// 服务必须已经停掉：dest 还有别的进程在用时直接拒绝。返回旧数据的备份路径（dest 原本不存在时为空）。
+func restoreSQLite(ctx context.Context, src, dest string) (string, error) {
+	if err := checkSQLiteFile(ctx, src); err != nil {
+		return "", fmt.Errorf("备份文件不可用: %w", err)
+	}
+
+	saved := ""
+	if _, err := os.Stat(dest); err == nil {
+		if err := ensureSQLiteIdle(ctx, dest); err != nil {
+			return "", err
+		}
+		// 只读打开、不走 openSQLiteStore：马上要被替换的库不需要跑建表迁移和数据补全。
+		old, err := openSQLiteReadOnly(dest)
+		if err != nil {
+			return "", fmt.Errorf("打开现有数据库失败: %w", err)
+		}
+		saved = dest + ".before-restore-" + time.Now().Format("20060102-150405")
+		err = backupSQLite(ctx, old, saved)
+		_ = old.Close()
+		if err != nil {
+			return "", fmt.Errorf("备份现有数据库失败: %w", err)
+		}
+	}
+
+	tmp := dest + ".restore-tmp"
+	if err := copyFile(src, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return saved, err
+	}
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(dest + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			_ = os.Remove(tmp)
+			return saved, err
+		}
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		_ = os.Remove(tmp)
+		return saved, err
+	}
+	return saved, nil
+}
+
+// openSQLiteReadOnly 只读打开一个已有的 SQLite 库，不建表、不迁移，备份和恢复前另存时用。
+// 服务正在写的时候也能读到一致的快照（WAL 模式），遇到锁最多等 5 秒。
+func openSQLiteReadOnly(path string) (*sql.DB, error) {
+	return sql.Open("sqlite", fmt.Sprintf("file:%s?mode=ro&_pragma=busy_timeout(5000)", path))
+}
+
+// ensureSQLiteIdle 确认没有别的进程开着这个库：试着拿一次排他锁，拿不到说明服务还在运行。
+// 服务跑着的时候，哪怕空闲，它的连接也占着库，排他锁会立刻失败（busy_timeout 为 0，不等待）。
+// 上次异常退出留下的 -wal 不算：拿到锁之后 SQLite 会把它合并回主文件，数据不会丢。
+func ensureSQLiteIdle(ctx context.Context, path string) error {
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_pragma=busy_timeout(0)&_pragma=locking_mode(EXCLUSIVE)", path))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.ExecContext(ctx, `BEGIN EXCLUSIVE`); err != nil {
+		return fmt.Errorf("数据库正被其他进程使用（服务还在运行？），请先停止服务再恢复: %w", err)
+	}
+	_, err = db.ExecContext(ctx, `COMMIT`)
+	return err
+}
+
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+const backupPrefix, backupSuffix = "feedback-", ".db"
+
+// runScheduledBackups 每隔 BACKUP_INTERVAL 往 BACKUP_DIR 写一份快照，只保留最近 BACKUP_KEEP 份。
+func runScheduledBackups(ctx context.Context, db *sql.DB, cfg Config) {
+	t := time.NewTicker(cfg.BackupInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		dest := filepath.Join(cfg.BackupDir, backupPrefix+time.Now().Format("20060102-150405")+backupSuffix)
+		bctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+		err := backupSQLite(bctx, db, dest)
+		cancel()
+		if err != nil {
+			slog.Error("定时备份失败", "err", err)
+			continue
+		}
+		slog.Info("定时备份完成", "path", dest)
+
+		if err := pruneBackups(cfg.BackupDir, cfg.BackupKeep); err != nil {
+			slog.Error("清理旧备份失败", "err", err)
+		}
+	}
+}
+
+// pruneBackups 删掉 dir 里多出来的旧快照。文件名带时间戳，按名字排序就是按时间排序。
+func pruneBackups(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, e := range entries {
+		n := e.Name()
+		if !e.IsDir() && strings.HasPrefix(n, backupPrefix) && strings.HasSuffix(n, backupSuffix) {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	for len(names) > keep {
+		if err := os.Remove(filepath.Join(dir, names[0])); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// runBackupCommand 实现 `feedback backup <dest>`，可以在服务运行时执行。
+func runBackupCommand(cfg Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New("用法: feedback backup <目标文件>")
+	}
+	path, ok := cfg.sqlitePath()
+	if !ok {
+		return errors.New("backup 只支持 SQLite；PostgreSQL 请用 pg_dump")
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("数据库不可用: %w", err)
+	}
+	// 服务可能正开着这个库，只读打开就好：建表迁移和数据补全是服务启动时的事，备份命令不碰。
+	db, err := openSQLiteReadOnly(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	if err := backupSQLite(ctx, db, args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("已备份到 %s（integrity_check: ok）\n", args[0])
+	return nil
+}
+
+// runRestoreCommand 实现 `feedback restore <src>`。恢复会整个替换数据库，先停掉服务再执行。
+func runRestoreCommand(cfg Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New("用法: feedback restore <备份文件>（执行前请先停止服务）")
+	}
+	path, ok := cfg.sqlitePath()
+	if !ok {
+		return errors.New("restore 只支持 SQLite；PostgreSQL 请用 pg_restore")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	saved, err := restoreSQLite(ctx, args[0], path)
+	if err != nil {
+		return err
+	}
+	if saved != "" {
+		fmt.Printf("原数据库已另存为 %s\n", saved)
+	}
+	fmt.Printf("已从 %s 恢复到 %s\n", args[0], path)
+	return nil
+}
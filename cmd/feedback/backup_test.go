@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRestoreSQLite(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// 备份源：一个正常建好表、有数据的库。
+	src := filepath.Join(dir, "src.db")
+	s, err := openSQLiteStore(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &User{ID: "u1", LinuxDoID: "1", Username: "alice", CreatedAt: testEpoch}
+	if err := s.CreateUser(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	backup := filepath.Join(dir, "backup.db")
+	if err := backupSQLite(ctx, s.db.reader(), backup); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.db.Close()
+
+	// 被替换的库故意用别的表结构：恢复前另存时不能给它跑建表迁移。
+	dest := filepath.Join(dir, "data.db")
+	old, err := sql.Open("sqlite", "file:"+dest+"?_pragma=journal_mode(WAL)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := old.Exec(`CREATE TABLE legacy(x TEXT); INSERT INTO legacy VALUES('old')`); err != nil {
+		t.Fatal(err)
+	}
+
+	// 还有进程开着 dest 时拒绝恢复。
+	if _, err := restoreSQLite(ctx, backup, dest); err == nil || !strings.Contains(err.Error(), "先停止服务") {
+		t.Fatalf("restore while in use: err = %v", err)
+	}
+	_ = old.Close()
+
+	saved, err := restoreSQLite(ctx, backup, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tables := sqliteTables(t, saved); strings.Join(tables, ",") != "legacy" {
+		t.Fatalf("saved copy tables = %v, want only legacy", tables)
+	}
+
+	r, err := openSQLiteStore(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.db.Close()
+	if got, err := r.UserByID(ctx, "u1"); err != nil || got.Username != "alice" {
+		t.Fatalf("restored user = %+v, %v", got, err)
+	}
+	if _, err := os.Stat(dest + ".restore-tmp"); !os.IsNotExist(err) {
+		t.Fatalf("temp file left behind: %v", err)
+	}
+}
+
+func TestBackupCommandReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	// 老结构的库，而且有别的连接开着（相当于服务在运行）：备份照样能做，但不能给它跑迁移。
+	path := filepath.Join(dir, "data.db")
+	live, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer live.Close()
+	if _, err := live.Exec(`CREATE TABLE legacy(x TEXT); INSERT INTO legacy VALUES('old')`); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "backup.db")
+	if err := runBackupCommand(Config{DatabasePath: path}, []string{out}); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{path, out} {
+		if tables := sqliteTables(t, p); strings.Join(tables, ",") != "legacy" {
+			t.Fatalf("%s tables = %v, want only legacy", filepath.Base(p), tables)
+		}
+	}
+
+	if err := runBackupCommand(Config{DatabasePath: filepath.Join(dir, "missing.db")}, []string{filepath.Join(dir, "x.db")}); err == nil {
+		t.Fatal("backup of a missing database succeeded")
+	}
+}
+
+func sqliteTables(t *testing.T, path string) []string {
+	t.Helper()
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type = 'table' ORDER BY name`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var n string
+		if err := rows.Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, n)
+	}
+	return names
+}
@@ -3,13 +3,20 @@ package main
 import (
 	"errors"
 	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
 	ListenAddr   string
 	DatabasePath string
+	// DatabaseURL 非空时优先于 DatabasePath：postgres://… 用 PostgreSQL，sqlite:路径 用 SQLite。
+	DatabaseURL string
 
 	SessionSecret []byte
 	AdminKey      string
@@ -22,11 +29,113 @@ type Config struct {
 	LinuxDoAuthURL     string
 	LinuxDoTokenURL    string
 	LinuxDoUserinfoURL string
+
+	// 反垃圾：单条反馈最多允许的链接数；注册不满 NewAccountHours 小时的账号每小时最多发 NewAccountMaxPerHour 条。
+	SpamMaxLinks         int
+	NewAccountHours      int
+	NewAccountMaxPerHour int
+
+	// 开启后新的公开反馈先进审核队列，管理员通过后才出现在广场。
+	RequireApproval bool
+
+	// 同一条内容被这么多个不同用户举报后自动隐藏，0 表示不自动隐藏。
+	ReportHideThreshold int
+
+	// 渲染后的 Markdown 在内存里缓存多少 MB，0 表示不缓存。
+	MarkdownCacheMB int
+
+	// 定时备份（仅 SQLite）：BackupDir 为空表示不开启；只保留最近 BackupKeep 份。
+	BackupDir      string
+	BackupInterval time.Duration
+	BackupKeep     int
+
+	// 用户申请注销后等 AccountDeleteGrace 再执行；AccountDeletePolicy 决定其反馈是删除还是匿名保留。
+	AccountDeletePolicy string
+	AccountDeleteGrace  time.Duration
+
+	// /metrics：MetricsAddr 非空时在单独的地址上提供（可以只监听内网）；
+	// 否则挂在主服务上，且必须配置 MetricsToken。两者都没配就不提供。
+	MetricsAddr  string
+	MetricsToken string
+
+	// 原生 HTTPS：证书和私钥文件都配了才开启，文件更新或收到 SIGHUP 时热加载。
+	// HTTPRedirectAddr 非空时另开一个明文端口，把请求 301 到 APP_BASE_URL。
+	TLSCertFile      string
+	TLSKeyFile       string
+	HTTPRedirectAddr string
+
+	// HSTS：站点走 HTTPS 时下发 Strict-Transport-Security，天数为 0 表示不发。
+	HSTSMaxAgeDays        int
+	HSTSIncludeSubdomains bool
+
+	// 配了就要求访问指标端口的客户端出示这个 CA 签发的证书（mTLS），需要同时配置 TLS 证书和 METRICS_ADDR。
+	MetricsClientCAFile string
+
+	// CSP 的 frame-ancestors，默认 'none' 禁止被嵌入；要嵌到别的站里就填对方的源，空格分隔。
+	// CSPReportOnly 打开时只上报不拦截，上线新策略前先观察用。
+	FrameAncestors string
+	CSPReportOnly  bool
+
+	// /readyz 是否顺带检查 Linux DO 可达（开启后 OAuth 没配完整也算未就绪）。
+	ReadyzCheckOAuth bool
+
+	// 日志级别（debug/info/warn/error）和格式（text/json）。
+	LogLevel  slog.Level
+	LogFormat string
+
+	// Production 对应 APP_ENV=production：这时校验更严，比如不允许用默认的 ADMIN_KEY。
+	Production bool
 }
 
+// defaultAdminKey 是没配 ADMIN_KEY 时的兜底值，仓库里公开可见，只能用于本地开发。
+const defaultAdminKey = "gCM61tTRDmGc1zGU4o2R"
+
+// minSecretLen 是 SESSION_SECRET 的最短长度（字节）。
+const minSecretLen = 32
+
+const (
+	deletePolicyAnonymize = "anonymize"
+	deletePolicyDelete    = "delete"
+)
+
 func loadConfig() (Config, error) {
-	get := func(name string) string { return strings.TrimSpace(os.Getenv(name)) }
+	cfg, _, err := readConfig(os.Getenv)
+	return cfg, err
+}
 
+// readConfig 先读 CONFIG_FILE 指向的配置文件（可选），再用环境变量覆盖，最后整体校验。
+// 第二个返回值记录每个配置项的来源（env / file），给 `feedback config check` 展示用。
+func readConfig(getenv func(string) string) (Config, map[string]string, error) {
+	var file map[string]string
+	if p := strings.TrimSpace(getenv("CONFIG_FILE")); p != "" {
+		var err error
+		if file, err = readConfigFile(p); err != nil {
+			return Config{}, nil, err
+		}
+	}
+	sources := map[string]string{}
+	get := func(name string) string {
+		if v := strings.TrimSpace(getenv(name)); v != "" {
+			sources[name] = "env"
+			return v
+		}
+		if v, ok := file[name]; ok {
+			sources[name] = "file"
+			return strings.TrimSpace(v)
+		}
+		return ""
+	}
+	cfg, err := buildConfig(get)
+	if err != nil {
+		return Config{}, sources, err
+	}
+	if err := cfg.validate(); err != nil {
+		return Config{}, sources, err
+	}
+	return cfg, sources, nil
+}
+
+func buildConfig(get func(string) string) (Config, error) {
 	listen := get("LISTEN_ADDR")
 	if listen == "" {
 		listen = "127.0.0.1:3000"
@@ -39,12 +148,132 @@ func loadConfig() (Config, error) {
 
 	secret := get("SESSION_SECRET")
 	if secret == "" {
-		return Config{}, errors.New("缺少 SESSION_SECRET 环境变量（建议用 32+ 字符随机串）")
+		return Config{}, errors.New("缺少 SESSION_SECRET（环境变量或配置文件，32+ 字符随机串）")
 	}
 
 	adminKey := get("ADMIN_KEY")
 	if adminKey == "" {
-		adminKey = "gCM61tTRDmGc1zGU4o2R"
+		adminKey = defaultAdminKey
+	}
+
+	getInt := func(name string, def int) (int, error) {
+		v := get(name)
+		if v == "" {
+			return def, nil
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("%s 必须是非负整数: %q", name, v)
+		}
+		return n, nil
+	}
+
+	getBool := func(name string) (bool, error) {
+		switch strings.ToLower(get(name)) {
+		case "", "0", "false", "no", "off":
+			return false, nil
+		case "1", "true", "yes", "on":
+			return true, nil
+		}
+		return false, fmt.Errorf("%s 只能是 true/false: %q", name, get(name))
+	}
+
+	maxLinks, err := getInt("SPAM_MAX_LINKS", 5)
+	if err != nil {
+		return Config{}, err
+	}
+	newAccountHours, err := getInt("NEW_ACCOUNT_HOURS", 24)
+	if err != nil {
+		return Config{}, err
+	}
+	newAccountMax, err := getInt("NEW_ACCOUNT_MAX_PER_HOUR", 3)
+	if err != nil {
+		return Config{}, err
+	}
+
+	requireApproval, err := getBool("REQUIRE_APPROVAL")
+	if err != nil {
+		return Config{}, err
+	}
+	reportThreshold, err := getInt("REPORT_HIDE_THRESHOLD", 3)
+	if err != nil {
+		return Config{}, err
+	}
+
+	markdownCacheMB, err := getInt("MARKDOWN_CACHE_MB", 32)
+	if err != nil {
+		return Config{}, err
+	}
+
+	backupInterval := 24 * time.Hour
+	if v := get("BACKUP_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Minute {
+			return Config{}, fmt.Errorf("BACKUP_INTERVAL 必须是不小于 1m 的时长（如 6h）: %q", v)
+		}
+		backupInterval = d
+	}
+	backupKeep, err := getInt("BACKUP_KEEP", 7)
+	if err != nil {
+		return Config{}, err
+	}
+
+	deletePolicy := strings.ToLower(get("ACCOUNT_DELETE_POLICY"))
+	switch deletePolicy {
+	case "":
+		deletePolicy = deletePolicyAnonymize
+	case deletePolicyAnonymize, deletePolicyDelete:
+	default:
+		return Config{}, fmt.Errorf("ACCOUNT_DELETE_POLICY 只能是 anonymize 或 delete: %q", deletePolicy)
+	}
+	deleteGraceDays, err := getInt("ACCOUNT_DELETE_GRACE_DAYS", 7)
+	if err != nil {
+		return Config{}, err
+	}
+
+	readyzOAuth, err := getBool("READYZ_CHECK_OAUTH")
+	if err != nil {
+		return Config{}, err
+	}
+
+	hstsDays, err := getInt("HSTS_MAX_AGE_DAYS", 180)
+	if err != nil {
+		return Config{}, err
+	}
+	hstsSub, err := getBool("HSTS_INCLUDE_SUBDOMAINS")
+	if err != nil {
+		return Config{}, err
+	}
+
+	frameAncestors := strings.Join(strings.Fields(get("CSP_FRAME_ANCESTORS")), " ")
+	if frameAncestors == "" {
+		frameAncestors = "'none'"
+	}
+	cspReportOnly, err := getBool("CSP_REPORT_ONLY")
+	if err != nil {
+		return Config{}, err
+	}
+
+	logLevel, err := parseLogLevel(get("LOG_LEVEL"))
+	if err != nil {
+		return Config{}, err
+	}
+	logFormat := strings.ToLower(get("LOG_FORMAT"))
+	switch logFormat {
+	case "":
+		logFormat = "text"
+	case "text", "json":
+	default:
+		return Config{}, fmt.Errorf("LOG_FORMAT 只能是 text 或 json: %q", logFormat)
+	}
+
+	production := false
+	switch env := strings.ToLower(get("APP_ENV")); env {
+	case "", "development", "dev":
+	case "production", "prod":
+		production = true
+	default:
+		return Config{}, fmt.Errorf("APP_ENV 只能是 development 或 production: %q", env)
 	}
 
 	base := strings.TrimRight(get("APP_BASE_URL"), "/")
@@ -55,6 +284,7 @@ func loadConfig() (Config, error) {
 	cfg := Config{
 		ListenAddr:    listen,
 		DatabasePath:  dbPath,
+		DatabaseURL:   get("DATABASE_URL"),
 		SessionSecret: []byte(secret),
 		AdminKey:      adminKey,
 		AppBaseURL:    base,
@@ -64,6 +294,43 @@ func loadConfig() (Config, error) {
 		LinuxDoAuthURL:      get("LINUXDO_AUTH_URL"),
 		LinuxDoTokenURL:     get("LINUXDO_TOKEN_URL"),
 		LinuxDoUserinfoURL:  get("LINUXDO_USERINFO_URL"),
+
+		SpamMaxLinks:         maxLinks,
+		NewAccountHours:      newAccountHours,
+		NewAccountMaxPerHour: newAccountMax,
+		RequireApproval:      requireApproval,
+		ReportHideThreshold:  reportThreshold,
+
+		MarkdownCacheMB: markdownCacheMB,
+
+		BackupDir:      get("BACKUP_DIR"),
+		BackupInterval: backupInterval,
+		BackupKeep:     backupKeep,
+
+		AccountDeletePolicy: deletePolicy,
+		AccountDeleteGrace:  time.Duration(deleteGraceDays) * 24 * time.Hour,
+
+		MetricsAddr:  get("METRICS_ADDR"),
+		MetricsToken: get("METRICS_TOKEN"),
+
+		TLSCertFile:      get("TLS_CERT_FILE"),
+		TLSKeyFile:       get("TLS_KEY_FILE"),
+		HTTPRedirectAddr: get("HTTP_REDIRECT_ADDR"),
+
+		HSTSMaxAgeDays:        hstsDays,
+		HSTSIncludeSubdomains: hstsSub,
+
+		MetricsClientCAFile: get("METRICS_CLIENT_CA_FILE"),
+
+		FrameAncestors: frameAncestors,
+		CSPReportOnly:  cspReportOnly,
+
+		ReadyzCheckOAuth: readyzOAuth,
+
+		LogLevel:  logLevel,
+		LogFormat: logFormat,
+
+		Production: production,
 	}
 
 	// OAuth 相关字段允许为空：这样可以在不开登录的情况下先跑起来看页面。
@@ -85,3 +352,127 @@ func (c Config) validateLinuxDo() error {
 	return fmt.Errorf("Linux DO Connect 未配置完整：需要 LINUXDO_CLIENT_ID/SECRET + LINUXDO_AUTH_URL/TOKEN_URL/USERINFO_URL")
 }
 
+// validate 在启动时一次性检查所有配置，把问题全部列出来，而不是等用到时才报错。
+func (c Config) validate() error {
+	var errs []error
+	bad := func(format string, args ...any) { errs = append(errs, fmt.Errorf(format, args...)) }
+
+	if len(c.SessionSecret) < minSecretLen {
+		bad("SESSION_SECRET 至少要 %d 个字符（当前 %d）", minSecretLen, len(c.SessionSecret))
+	}
+	if c.Production {
+		if c.AdminKey == defaultAdminKey {
+			bad("APP_ENV=production 时必须设置 ADMIN_KEY，不能用仓库里的默认值")
+		} else if len(c.AdminKey) < 16 {
+			bad("APP_ENV=production 时 ADMIN_KEY 至少要 16 个字符")
+		}
+	}
+
+	if err := checkHTTPURL(c.AppBaseURL); err != nil {
+		bad("APP_BASE_URL %v", err)
+	}
+
+	// Linux DO 要么完全不配（不开登录），要么五项都配齐。
+	oauth := []struct{ name, value string }{
+		{"LINUXDO_CLIENT_ID", c.LinuxDoClientID},
+		{"LINUXDO_CLIENT_SECRET", c.LinuxDoClientSecret},
+		{"LINUXDO_AUTH_URL", c.LinuxDoAuthURL},
+		{"LINUXDO_TOKEN_URL", c.LinuxDoTokenURL},
+		{"LINUXDO_USERINFO_URL", c.LinuxDoUserinfoURL},
+	}
+	var missing []string
+	for _, o := range oauth {
+		if o.value == "" {
+			missing = append(missing, o.name)
+			continue
+		}
+		if strings.HasSuffix(o.name, "_URL") {
+			if err := checkHTTPURL(o.value); err != nil {
+				bad("%s %v", o.name, err)
+			}
+		}
+	}
+	if len(missing) > 0 && len(missing) < len(oauth) {
+		bad("Linux DO Connect 只配了一部分，还缺: %s", strings.Join(missing, ", "))
+	}
+
+	if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		bad("LISTEN_ADDR 不是合法的 host:port: %q", c.ListenAddr)
+	}
+	if c.MetricsAddr != "" {
+		if _, _, err := net.SplitHostPort(c.MetricsAddr); err != nil {
+			bad("METRICS_ADDR 不是合法的 host:port: %q", c.MetricsAddr)
+		} else if c.MetricsAddr == c.ListenAddr {
+			bad("METRICS_ADDR 不能和 LISTEN_ADDR 相同")
+		}
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		bad("TLS_CERT_FILE 和 TLS_KEY_FILE 要一起配置")
+	}
+	if c.HTTPRedirectAddr != "" {
+		if _, _, err := net.SplitHostPort(c.HTTPRedirectAddr); err != nil {
+			bad("HTTP_REDIRECT_ADDR 不是合法的 host:port: %q", c.HTTPRedirectAddr)
+		} else if c.HTTPRedirectAddr == c.ListenAddr {
+			bad("HTTP_REDIRECT_ADDR 不能和 LISTEN_ADDR 相同")
+		}
+		if !c.AppBaseURLHasTLS() {
+			bad("配置了 HTTP_REDIRECT_ADDR 时 APP_BASE_URL 必须是 https://")
+		}
+	}
+	if c.MetricsClientCAFile != "" && (c.MetricsAddr == "" || !c.tlsEnabled()) {
+		bad("METRICS_CLIENT_CA_FILE 需要同时配置 METRICS_ADDR 和 TLS_CERT_FILE / TLS_KEY_FILE")
+	}
+
+	for _, src := range strings.Fields(c.FrameAncestors) {
+		if src == "'none'" || src == "'self'" {
+			if c.FrameAncestors != src && src == "'none'" {
+				bad("CSP_FRAME_ANCESTORS 里 'none' 不能和其他来源一起用")
+			}
+			continue
+		}
+		if err := checkHTTPURL(src); err != nil || strings.ContainsAny(src, ";,") {
+			bad("CSP_FRAME_ANCESTORS 只能是 'none'、'self' 或 https://host 这样的源: %q", src)
+		}
+	}
+
+	if u := c.DatabaseURL; u != "" {
+		if _, ok := c.sqlitePath(); !ok && !strings.HasPrefix(u, "postgres://") && !strings.HasPrefix(u, "postgresql://") {
+			bad("DATABASE_URL 只支持 sqlite:路径 或 postgres://…")
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// warnings 是不影响启动、但值得在日志里提醒一下的配置问题。
+func (c Config) warnings() []string {
+	var list []string
+	if !c.Production && c.AdminKey == defaultAdminKey {
+		list = append(list, "ADMIN_KEY 未设置，正在使用仓库里的默认值，只适合本地开发")
+	}
+	if c.tlsEnabled() && !c.AppBaseURLHasTLS() {
+		list = append(list, "已开启 HTTPS 但 APP_BASE_URL 不是 https://，会话 Cookie 不会带 Secure")
+	} else if c.Production && !c.AppBaseURLHasTLS() {
+		list = append(list, "APP_ENV=production 但 APP_BASE_URL 不是 https，会话 Cookie 不会带 Secure")
+	}
+	return list
+}
+
+func (c Config) tlsEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+func checkHTTPURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("不是合法的 URL: %q", s)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("必须以 http:// 或 https:// 开头: %q", s)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("缺少主机名: %q", s)
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// 配置文件和环境变量用同一套名字：键名转成大写、嵌套的表用下划线连起来，
+// 所以下面两种写法都对应 LINUXDO_CLIENT_ID：
+//
+//	linuxdo_client_id = "…"
+//
+//	[linuxdo]
+//	client_id = "…"
+//
+// 同名的环境变量优先于配置文件。
+
+// readConfigFile 读 TOML（.toml）或 YAML（.yaml / .yml）配置文件，展平成「环境变量名 → 值」。
+// 出现不认识的配置项直接报错，免得拼错了名字还以为生效了。
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %w", err)
+	}
+
+	raw := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("配置文件只支持 .toml / .yaml / .yml: %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("解析配置文件 %s 失败: %w", path, err)
+	}
+
+	out := map[string]string{}
+	if err := flattenConfig("", raw, out); err != nil {
+		return nil, fmt.Errorf("配置文件 %s: %w", path, err)
+	}
+
+	known := map[string]bool{}
+	for _, e := range (Config{}).entries() {
+		known[e.Name] = true
+	}
+	var unknown []string
+	for k := range out {
+		if !known[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("配置文件 %s 里有不认识的配置项: %s", path, strings.Join(unknown, ", "))
+	}
+	return out, nil
+}
+
+func flattenConfig(prefix string, m map[string]any, out map[string]string) error {
+	for k, v := range m {
+		name := strings.ToUpper(strings.ReplaceAll(k, "-", "_"))
+		if prefix != "" {
+			name = prefix + "_" + name
+		}
+		switch v := v.(type) {
+		case map[string]any:
+			if err := flattenConfig(name, v, out); err != nil {
+				return err
+			}
+		case string:
+			out[name] = v
+		case bool:
+			out[name] = strconv.FormatBool(v)
+		case int:
+			out[name] = strconv.Itoa(v)
+		case int64:
+			out[name] = strconv.FormatInt(v, 10)
+		case float64:
+			out[name] = strconv.FormatFloat(v, 'f', -1, 64)
+		case nil:
+		default:
+			return fmt.Errorf("%s 的值类型不支持（只能是字符串、数字或布尔）", name)
+		}
+	}
+	return nil
+}
+
+type configEntry struct {
+	Name   string
+	Value  string
+	Secret bool
+}
+
+// entries 按配置项列出生效的值。新增配置项时要加在这里，否则配置文件里写了会被当成未知项。
+func (c Config) entries() []configEntry {
+	b := strconv.FormatBool
+	i := strconv.Itoa
+	env := "development"
+	if c.Production {
+		env = "production"
+	}
+	return []configEntry{
+		{Name: "APP_ENV", Value: env},
+		{Name: "LISTEN_ADDR", Value: c.ListenAddr},
+		{Name: "APP_BASE_URL", Value: c.AppBaseURL},
+		{Name: "DATABASE_PATH", Value: c.DatabasePath},
+		{Name: "DATABASE_URL", Value: redactURL(c.DatabaseURL)},
+		{Name: "SESSION_SECRET", Value: string(c.SessionSecret), Secret: true},
+		{Name: "ADMIN_KEY", Value: c.AdminKey, Secret: true},
+		{Name: "LINUXDO_CLIENT_ID", Value: c.LinuxDoClientID},
+		{Name: "LINUXDO_CLIENT_SECRET", Value: c.LinuxDoClientSecret, Secret: true},
+		{Name: "LINUXDO_AUTH_URL", Value: c.LinuxDoAuthURL},
+		{Name: "LINUXDO_TOKEN_URL", Value: c.LinuxDoTokenURL},
+		{Name: "LINUXDO_USERINFO_URL", Value: c.LinuxDoUserinfoURL},
+		{Name: "SPAM_MAX_LINKS", Value: i(c.SpamMaxLinks)},
+		{Name: "NEW_ACCOUNT_HOURS", Value: i(c.NewAccountHours)},
+		{Name: "NEW_ACCOUNT_MAX_PER_HOUR", Value: i(c.NewAccountMaxPerHour)},
+		{Name: "REQUIRE_APPROVAL", Value: b(c.RequireApproval)},
+		{Name: "REPORT_HIDE_THRESHOLD", Value: i(c.ReportHideThreshold)},
+		{Name: "MARKDOWN_CACHE_MB", Value: i(c.MarkdownCacheMB)},
+		{Name: "BACKUP_DIR", Value: c.BackupDir},
+		{Name: "BACKUP_INTERVAL", Value: c.BackupInterval.String()},
+		{Name: "BACKUP_KEEP", Value: i(c.BackupKeep)},
+		{Name: "ACCOUNT_DELETE_POLICY", Value: c.AccountDeletePolicy},
+		{Name: "ACCOUNT_DELETE_GRACE_DAYS", Value: i(int(c.AccountDeleteGrace / (24 * time.Hour)))},
+		{Name: "METRICS_ADDR", Value: c.MetricsAddr},
+		{Name: "METRICS_TOKEN", Value: c.MetricsToken, Secret: true},
+		{Name: "TLS_CERT_FILE", Value: c.TLSCertFile},
+		{Name: "TLS_KEY_FILE", Value: c.TLSKeyFile},
+		{Name: "HTTP_REDIRECT_ADDR", Value: c.HTTPRedirectAddr},
+		{Name: "HSTS_MAX_AGE_DAYS", Value: i(c.HSTSMaxAgeDays)},
+		{Name: "HSTS_INCLUDE_SUBDOMAINS", Value: b(c.HSTSIncludeSubdomains)},
+		{Name: "METRICS_CLIENT_CA_FILE", Value: c.MetricsClientCAFile},
+		{Name: "CSP_FRAME_ANCESTORS", Value: c.FrameAncestors},
+		{Name: "CSP_REPORT_ONLY", Value: b(c.CSPReportOnly)},
+		{Name: "READYZ_CHECK_OAUTH", Value: b(c.ReadyzCheckOAuth)},
+		{Name: "LOG_LEVEL", Value: strings.ToLower(c.LogLevel.String())},
+		{Name: "LOG_FORMAT", Value: c.LogFormat},
+	}
+}
+
+// redactURL 去掉连接串里的密码。
+func redactURL(s string) string {
+	if s == "" {
+		return ""
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	return u.Redacted()
+}
+
+// runConfigCommand 实现 `feedback config check`：按启动时同样的规则加载并校验配置，
+// 打印生效的值（密钥只显示长度）和来源。校验不通过时返回错误。
+func runConfigCommand(args []string) error {
+	if len(args) != 1 || args[0] != "check" {
+		return errors.New("用法: feedback config check（配置文件通过 CONFIG_FILE 指定）")
+	}
+	cfg, sources, err := readConfig(os.Getenv)
+	if err != nil {
+		return fmt.Errorf("配置有误:\n%w", err)
+	}
+
+	if p := os.Getenv("CONFIG_FILE"); p != "" {
+		fmt.Printf("配置文件: %s\n\n", p)
+	}
+	for _, e := range cfg.entries() {
+		v := e.Value
+		switch {
+		case e.Secret && v != "":
+			v = fmt.Sprintf("<已设置，%d 字符>", len(v))
+		case v == "":
+			v = "<空>"
+		}
+		src := sources[e.Name]
+		if src == "" {
+			src = "default"
+		}
+		fmt.Printf("%-26s %-8s %s\n", e.Name, src, v)
+	}
+	for _, w := range cfg.warnings() {
+		fmt.Printf("\n警告: %s", w)
+	}
+	fmt.Println("\n配置检查通过")
+	return nil
+}
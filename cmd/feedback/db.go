@@ -4,92 +4,256 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
+)
+
+type dialect int
 
-	_ "modernc.org/sqlite"
+const (
+	dialectSQLite dialect = iota
+	dialectPostgres
 )
 
-func openDB(path string) (*sql.DB, error) {
-	// modernc sqlite DSN 走 file: 前缀最稳。
-	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)", path)
-	db, err := sql.Open("sqlite", dsn)
+// DB 包一层 *sql.DB。SQL 统一按 ? 占位符写，跑在 PostgreSQL 上时自动改写成 $1、$2…，
+// 这样业务代码不用关心底下是哪种库。
+//
+// 内嵌的 *sql.DB 是写连接；read 不为空时 Query* 走它（SQLite 的只读连接池）。
+// 所以 INSERT … RETURNING 这类要写库的查询必须放在事务里，事务总是走写连接。
+type DB struct {
+	*sql.DB
+	read    *sql.DB
+	dialect dialect
+}
+
+func (d *DB) reader() *sql.DB {
+	if d.read != nil {
+		return d.read
+	}
+	return d.DB
+}
+
+func (d *DB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	defer observeDB("exec", query, time.Now())
+	return d.DB.ExecContext(ctx, d.rebind(query), args...)
+}
+
+func (d *DB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	defer observeDB("query", query, time.Now())
+	return d.reader().QueryContext(ctx, d.rebind(query), args...)
+}
+
+func (d *DB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	defer observeDB("query_row", query, time.Now())
+	return d.reader().QueryRowContext(ctx, d.rebind(query), args...)
+}
+
+func (d *DB) Close() error {
+	err := d.DB.Close()
+	if d.read != nil {
+		if rerr := d.read.Close(); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+func (d *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	tx, err := d.DB.BeginTx(ctx, opts)
 	if err != nil {
 		return nil, err
 	}
-	db.SetMaxOpenConns(1) // SQLite 单连接更省心
-	db.SetMaxIdleConns(1)
-	db.SetConnMaxLifetime(0)
+	return &Tx{Tx: tx, db: d}, nil
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	if err := db.PingContext(ctx); err != nil {
-		_ = db.Close()
-		return nil, err
+type Tx struct {
+	*sql.Tx
+	db *DB
+}
+
+func (t *Tx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	defer observeDB("exec", query, time.Now())
+	return t.Tx.ExecContext(ctx, t.db.rebind(query), args...)
+}
+
+func (t *Tx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	defer observeDB("query", query, time.Now())
+	return t.Tx.QueryContext(ctx, t.db.rebind(query), args...)
+}
+
+func (t *Tx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	defer observeDB("query_row", query, time.Now())
+	return t.Tx.QueryRowContext(ctx, t.db.rebind(query), args...)
+}
+
+// rebind 把 ? 换成 PostgreSQL 的 $n，单引号字符串里的 ? 不动。
+func (d *DB) rebind(query string) string {
+	if d.dialect != dialectPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	b.Grow(len(query) + 16)
+	n := 0
+	inQuote := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'':
+			inQuote = !inQuote
+		case c == '?' && !inQuote:
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteByte(c)
 	}
-	return db, nil
-}
-
-func ensureSchema(db *sql.DB) error {
-	stmts := []string{
-		`CREATE TABLE IF NOT EXISTS users (
-			id TEXT PRIMARY KEY,
-			linux_do_id TEXT NOT NULL UNIQUE,
-			username TEXT NOT NULL,
-			avatar_url TEXT,
-			created_at INTEGER NOT NULL
-		);`,
-		`CREATE TABLE IF NOT EXISTS feedbacks (
-			id TEXT PRIMARY KEY,
-			title TEXT NOT NULL,
-			content TEXT NOT NULL,
-			is_public INTEGER NOT NULL DEFAULT 1,
-			created_at INTEGER NOT NULL,
-			updated_at INTEGER NOT NULL,
-			user_id TEXT NOT NULL
-		);`,
-		`CREATE INDEX IF NOT EXISTS idx_feedbacks_public_created ON feedbacks(is_public, created_at);`,
-		`CREATE INDEX IF NOT EXISTS idx_feedbacks_user_created ON feedbacks(user_id, created_at);`,
-		`CREATE TABLE IF NOT EXISTS replies (
-			id TEXT PRIMARY KEY,
-			content TEXT NOT NULL,
-			created_at INTEGER NOT NULL,
-			feedback_id TEXT NOT NULL,
-			admin_user_id TEXT
-		);`,
-		`CREATE INDEX IF NOT EXISTS idx_replies_feedback_created ON replies(feedback_id, created_at);`,
+	return b.String()
+}
+
+// forUpdate 在 PostgreSQL 上给 SELECT 加行锁；SQLite 写本来就是串行的，不需要。
+func (d *DB) forUpdate() string {
+	if d.dialect == dialectPostgres {
+		return " FOR UPDATE"
 	}
+	return ""
+}
 
-	for _, s := range stmts {
-		if _, err := db.Exec(s); err != nil {
-			return err
+// schemaVersion 是当前代码需要的表结构版本。改表（加表、加列、加索引）时加一，
+// 建表流程最后把它写进 schema_version，/readyz 据此判断库是不是已经迁移到位。
+const schemaVersion = 7
+
+const (
+	schemaVersionTable = `CREATE TABLE IF NOT EXISTS schema_version (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		version INTEGER NOT NULL
+	);`
+	// 只往上写：多实例滚动升级时，旧版本实例重启不会把版本号改回去。
+	schemaVersionUpsert = `INSERT INTO schema_version(id, version) VALUES(1, %d)
+		ON CONFLICT(id) DO UPDATE SET version = excluded.version WHERE schema_version.version < excluded.version`
+)
+
+// storedSchemaVersion 读库里记录的表结构版本，没有记录时为 0。
+func (d *DB) storedSchemaVersion(ctx context.Context) (int, error) {
+	return readSchemaVersion(ctx, d)
+}
+
+// PingContext 同时检查写连接和只读连接池。
+func (d *DB) PingContext(ctx context.Context) error {
+	if err := d.DB.PingContext(ctx); err != nil {
+		return err
+	}
+	if d.read != nil {
+		return d.read.PingContext(ctx)
+	}
+	return nil
+}
+
+// openStore 按 DATABASE_URL 的 scheme 选择存储：postgres:// 走 PostgreSQL，
+// 其余（sqlite:路径，或者没配时用 DATABASE_PATH）走 SQLite。返回的 *DB 给审计、举报等辅助表直接用。
+func openStore(cfg Config) (Store, *DB, error) {
+	u := cfg.DatabaseURL
+	if strings.HasPrefix(u, "postgres://") || strings.HasPrefix(u, "postgresql://") {
+		s, err := openPostgresStore(u)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := afterOpen(s.sqlStore); err != nil {
+			_ = s.db.Close()
+			return nil, nil, err
+		}
+		return s, s.db, nil
+	}
+	path, ok := cfg.sqlitePath()
+	if !ok {
+		return nil, nil, fmt.Errorf("不支持的 DATABASE_URL：%q（支持 sqlite:路径 或 postgres://）", u)
+	}
+	s, err := openSQLiteStore(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := afterOpen(s.sqlStore); err != nil {
+		_ = s.db.Close()
+		return nil, nil, err
+	}
+	return s, s.db, nil
+}
+
+// afterOpen 是建表之后、开始服务之前的数据补全（新加的派生列给老数据补上值），
+// 只跑打开前的版本还没做过的那些。全部成功后才写入表结构版本，/readyz 这时才算迁移到位。
+func afterOpen(s *sqlStore) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	for _, b := range dataBackfills {
+		if s.schemaFrom >= b.version {
+			continue
 		}
+		if err := b.run(s, ctx); err != nil {
+			return fmt.Errorf("%s失败: %w", b.name, err)
+		}
+	}
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf(schemaVersionUpsert, schemaVersion)); err != nil {
+		return fmt.Errorf("写入表结构版本失败: %w", err)
 	}
+	s.schemaFrom = schemaVersion
 	return nil
 }
 
+// sqlitePath 返回 SQLite 数据库文件路径；配置的是其他数据库时 ok 为 false。
+func (c Config) sqlitePath() (string, bool) {
+	switch u := c.DatabaseURL; {
+	case u == "":
+		return c.DatabasePath, true
+	case strings.HasPrefix(u, "sqlite:"):
+		return strings.TrimPrefix(strings.TrimPrefix(u, "sqlite:"), "//"), true
+	}
+	return "", false
+}
+
 type User struct {
 	ID        string
 	LinuxDoID string
 	Username  string
 	AvatarURL string
 	CreatedAt time.Time
+
+	DeletionRequestedAt time.Time // 申请注销的时间，零值表示没有申请
+	DeletedAt           time.Time // 已注销（资料已匿名化）的时间
+}
+
+// deletedUsername 是注销后账号显示的名字。
+const deletedUsername = "已注销用户"
+
+func (u User) Deleted() bool {
+	return !u.DeletedAt.IsZero()
 }
 
 type Feedback struct {
 	ID        string
 	Title     string
 	Content   string
+	Excerpt   string // 纯文本摘要，写入时由 plainExcerpt 生成
 	IsPublic  bool
 	UserID    string
 	Username  string
 	CreatedAt time.Time
 	UpdatedAt time.Time
+
+	Moderation     string // published / pending / flagged / rejected
+	ModerationNote string // 系统标记原因，仅管理员可见
+	RejectReason   string // 管理员拒绝时填写，作者可见
+
+	Hidden bool // 被举报次数过多自动隐藏，或管理员手动隐藏
+
+	MergedInto string // 被管理员标记为重复时指向的反馈 ID
 }
 
 type Reply struct {
 	ID            string
 	Content       string
 	CreatedAt     time.Time
+	FeedbackID    string
+	AdminUserID   string
 	AdminUsername string
+	Hidden        bool
 }
 
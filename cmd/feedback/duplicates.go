@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// similarTerms 从标题里抽出用来找相似反馈的词：英文、数字按整词，中文按相邻两字切。
+func similarTerms(title string) []string {
+	seen := map[string]bool{}
+	var terms []string
+	add := func(t string) {
+		t = strings.ToLower(t)
+		if seen[t] || len(terms) >= 12 {
+			return
+		}
+		seen[t] = true
+		terms = append(terms, t)
+	}
+
+	var word, han []rune
+	flushWord := func() {
+		if len(word) >= 2 {
+			add(string(word))
+		}
+		word = word[:0]
+	}
+	flushHan := func() {
+		if len(han) == 1 {
+			add(string(han))
+		}
+		for i := 0; i+1 < len(han); i++ {
+			add(string(han[i : i+2]))
+		}
+		han = han[:0]
+	}
+	for _, r := range title {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flushWord()
+			han = append(han, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushHan()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushHan()
+		}
+	}
+	flushWord()
+	flushHan()
+	return terms
+}
+
+// similarFeedback 用广场同一套可见性条件找标题相近的公开反馈，按命中词数排序。
+// 至少一半的词出现在对方标题里才算相似。
+func (a *App) similarFeedback(ctx context.Context, title, excludeID string, limit int) ([]Feedback, error) {
+	terms := similarTerms(title)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	cands, err := a.store.ListFeedback(ctx, FeedbackQuery{
+		Square:    true,
+		TitleAny:  terms,
+		ExcludeID: excludeID,
+		Limit:     200,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	type scored struct {
+		f     Feedback
+		score int
+	}
+	var list []scored
+	for _, f := range cands {
+		lower := strings.ToLower(f.Title)
+		hits := 0
+		for _, t := range terms {
+			if strings.Contains(lower, t) {
+				hits++
+			}
+		}
+		if hits*2 >= len(terms) {
+			list = append(list, scored{f, hits})
+		}
+	}
+
+	sort.SliceStable(list, func(i, j int) bool { return list[i].score > list[j].score })
+	var out []Feedback
+	for i := 0; i < len(list) && i < limit; i++ {
+		out = append(out, list[i].f)
+	}
+	return out, nil
+}
+
+// parseFeedbackRef 接受反馈 ID、#ID、/square/ID 或完整链接。
+func parseFeedbackRef(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexAny(s, "?#"); i > 0 {
+		s = s[:i]
+	}
+	s = strings.TrimPrefix(s, "#")
+	return path.Base(strings.TrimRight(s, "/"))
+}
+
+func (a *App) handleAdminMerge(w http.ResponseWriter, r *http.Request) {
+	sess := a.readSession(r)
+	if !sess.IsAdmin {
+		http.NotFound(w, r)
+		return
+	}
+	id := strings.TrimSpace(r.PathValue("id"))
+	if err := r.ParseForm(); err != nil {
+		a.renderError(w, r, http.StatusBadRequest, "表单解析失败")
+		return
+	}
+	target := parseFeedbackRef(r.FormValue("target"))
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	item, err := a.store.FeedbackByID(ctx, id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	final, err := a.store.MergeFeedback(ctx, id, target)
+	if errors.Is(err, errBadMergeTarget) {
+		http.Redirect(w, r, "/square/"+id+"?merge_error=1", http.StatusFound)
+		return
+	}
+	if err != nil {
+		a.serverError(w, r, "写入失败", err)
+		return
+	}
+	a.audit(ctx, r, sess, auditFeedbackMerge, "feedback", id,
+		map[string]any{"merged_into": item.MergedInto},
+		map[string]any{"merged_into": final},
+	)
+	a.notifyWatchers(ctx, id, sess.UID, auditFeedbackMerge)
+
+	http.Redirect(w, r, "/square/"+id, http.StatusFound)
+}
+
+func (a *App) handleAdminUnmerge(w http.ResponseWriter, r *http.Request) {
+	sess := a.readSession(r)
+	if !sess.IsAdmin {
+		http.NotFound(w, r)
+		return
+	}
+	id := strings.TrimSpace(r.PathValue("id"))
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	item, err := a.store.FeedbackByID(ctx, id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if err := a.store.UnmergeFeedback(ctx, id); err != nil {
+		a.serverError(w, r, "写入失败", err)
+		return
+	}
+	a.audit(ctx, r, sess, auditFeedbackUnmerge, "feedback", id,
+		map[string]any{"merged_into": item.MergedInto},
+		map[string]any{"merged_into": ""},
+	)
+
+	http.Redirect(w, r, "/square/"+id, http.StatusFound)
+}
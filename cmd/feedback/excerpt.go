@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"log/slog"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// excerptRunes 是列表里摘要的最大长度（按字符数，不按字节），超出部分用省略号代替。
+const excerptRunes = 140
+
+// plainText 把 Markdown 解析成 AST 后只取文字：标题、列表、引用的符号都去掉，
+// 代码块和原始 HTML 整块跳过，图片留下替代文字，段落之间用一个空格隔开。
+func plainText(content string) string {
+	src := []byte(content)
+	doc := md.Parser().Parse(text.NewReader(src))
+
+	var b strings.Builder
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			if n.Type() == ast.TypeBlock {
+				b.WriteByte(' ')
+			}
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.CodeBlock, *ast.FencedCodeBlock, *ast.HTMLBlock, *ast.RawHTML:
+			return ast.WalkSkipChildren, nil
+		case *ast.Text:
+			v := n.Value(src)
+			if !n.IsRaw() {
+				v = util.ResolveEntityNames(util.ResolveNumericReferences(util.UnescapePunctuations(v)))
+			}
+			b.Write(v)
+			if n.SoftLineBreak() || n.HardLineBreak() {
+				b.WriteByte(' ')
+			}
+		case *ast.String:
+			b.Write(n.Value)
+		case *ast.AutoLink:
+			b.Write(n.Label(src))
+		case *refNode:
+			b.WriteString(n.text)
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// plainExcerpt 是写入时存进 feedbacks.excerpt 的摘要。
+func plainExcerpt(content string) string {
+	return truncateRunes(plainText(content), excerptRunes)
+}
+
+// searchPattern 是搜索词的不区分大小写匹配，和 SQL 里的 LIKE 一样按整个搜索串匹配。
+func searchPattern(q string) *regexp.Regexp {
+	q = strings.TrimSpace(q)
+	if q == "" {
+		return nil
+	}
+	return regexp.MustCompile(`(?i)` + regexp.QuoteMeta(q))
+}
+
+// searchExcerpt 给搜索结果换摘要：存好的摘要里已经有搜索词就直接用，
+// 否则（命中在正文后半段）从纯文本里截一段，让命中的位置出现在摘要开头附近。
+func searchExcerpt(f Feedback, q string) string {
+	re := searchPattern(q)
+	if re == nil || re.MatchString(f.Excerpt) {
+		return f.Excerpt
+	}
+	plain := plainText(f.Content)
+	loc := re.FindStringIndex(plain)
+	if loc == nil {
+		return f.Excerpt // 只有标题命中
+	}
+	start := utf8.RuneCountInString(plain[:loc[0]]) - excerptRunes/4
+	if start <= 0 {
+		return truncateRunes(plain, excerptRunes)
+	}
+	return "…" + truncateRunes(string([]rune(plain)[start:]), excerptRunes)
+}
+
+// highlight 转义 s，并把其中的搜索词用 <mark> 标出来。q 为空时只做转义。
+func highlight(s, q string) template.HTML {
+	re := searchPattern(q)
+	if re == nil {
+		return template.HTML(template.HTMLEscapeString(s))
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range re.FindAllStringIndex(s, -1) {
+		b.WriteString(template.HTMLEscapeString(s[last:m[0]]))
+		b.WriteString("<mark>" + template.HTMLEscapeString(s[m[0]:m[1]]) + "</mark>")
+		last = m[1]
+	}
+	b.WriteString(template.HTMLEscapeString(s[last:]))
+	return template.HTML(b.String())
+}
+
+// backfillExcerpts 给加 excerpt 列之前写入的反馈补上摘要。只在从版本 3 之前的库升级时跑一次（见 dataBackfills），
+// 纯文本为空（比如只有代码块）的行摘要仍然是空的，按 ID 翻页不会在它们上面打转。
+func (s *sqlStore) backfillExcerpts(ctx context.Context) error {
+	type row struct{ id, content string }
+	after, total := "", 0
+	for {
+		rows, err := s.db.QueryContext(ctx,
+			`SELECT id, content FROM feedbacks WHERE excerpt = '' AND id > ? ORDER BY id LIMIT 200`, after)
+		if err != nil {
+			return err
+		}
+		var batch []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.id, &r.content); err != nil {
+				rows.Close()
+				return err
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		for _, r := range batch {
+			if e := plainExcerpt(r.content); e != "" {
+				if _, err := s.db.ExecContext(ctx, `UPDATE feedbacks SET excerpt = ? WHERE id = ?`, e, r.id); err != nil {
+					return err
+				}
+				total++
+			}
+		}
+		after = batch[len(batch)-1].id
+	}
+	if total > 0 {
+		slog.InfoContext(ctx, "已补全反馈摘要", "count", total)
+	}
+	return nil
+}
@@ -0,0 +1,653 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportRecord 是 JSONL 导出 / 导入里的一行，Type 决定哪些字段有意义。
+// 时间一律存 Unix 秒，导回去不会丢精度；ID 原样保留，重复导入同一份文件不会产生新数据。
+type exportRecord struct {
+	Type      string `json:"type"` // user / feedback / reply
+	ID        string `json:"id"`
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at,omitempty"`
+
+	LinuxDoID string `json:"linux_do_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	AvatarURL string `json:"avatar_url,omitempty"`
+
+	UserID         string `json:"user_id,omitempty"`
+	Title          string `json:"title,omitempty"`
+	Content        string `json:"content,omitempty"`
+	IsPublic       bool   `json:"is_public,omitempty"`
+	Moderation     string `json:"moderation,omitempty"`
+	ModerationNote string `json:"moderation_note,omitempty"`
+	RejectReason   string `json:"reject_reason,omitempty"`
+	Hidden         bool   `json:"hidden,omitempty"`
+	MergedInto     string `json:"merged_into,omitempty"`
+
+	FeedbackID  string `json:"feedback_id,omitempty"`
+	AdminUserID string `json:"admin_user_id,omitempty"`
+}
+
+const (
+	exportTypeUser     = "user"
+	exportTypeFeedback = "feedback"
+	exportTypeReply    = "reply"
+)
+
+func userRecord(u User) exportRecord {
+	return exportRecord{
+		Type: exportTypeUser, ID: u.ID, CreatedAt: u.CreatedAt.Unix(),
+		LinuxDoID: u.LinuxDoID, Username: u.Username, AvatarURL: u.AvatarURL,
+	}
+}
+
+func feedbackRecord(f Feedback) exportRecord {
+	return exportRecord{
+		Type: exportTypeFeedback, ID: f.ID, CreatedAt: f.CreatedAt.Unix(), UpdatedAt: f.UpdatedAt.Unix(),
+		UserID: f.UserID, Title: f.Title, Content: f.Content, IsPublic: f.IsPublic,
+		Moderation: f.Moderation, ModerationNote: f.ModerationNote, RejectReason: f.RejectReason,
+		Hidden: f.Hidden, MergedInto: f.MergedInto,
+	}
+}
+
+func replyRecord(r Reply) exportRecord {
+	return exportRecord{
+		Type: exportTypeReply, ID: r.ID, CreatedAt: r.CreatedAt.Unix(),
+		FeedbackID: r.FeedbackID, AdminUserID: r.AdminUserID, Content: r.Content, Hidden: r.Hidden,
+	}
+}
+
+// ExportFilter 是导出时可选的筛选条件，全部留空就是整库导出。
+type ExportFilter struct {
+	From       string // YYYY-MM-DD，按反馈创建时间
+	To         string // YYYY-MM-DD，含当天
+	PublicOnly bool
+	Moderation string
+}
+
+func (f ExportFilter) isZero() bool {
+	return f == ExportFilter{}
+}
+
+func exportFilterFromRequest(r *http.Request) ExportFilter {
+	q := r.URL.Query()
+	return ExportFilter{
+		From:       strings.TrimSpace(q.Get("from")),
+		To:         strings.TrimSpace(q.Get("to")),
+		PublicOnly: q.Get("public") == "1",
+		Moderation: strings.TrimSpace(q.Get("moderation")),
+	}
+}
+
+type exportData struct {
+	Users    []User
+	Feedback []Feedback
+	Replies  []Reply
+}
+
+// collectExport 取出要导出的数据。整库导出时带上所有用户；筛选导出时只带被引用到的用户，
+// 这样导出文件自己就是完整的，可以直接导进一个空库。
+func collectExport(ctx context.Context, store Store, f ExportFilter) (*exportData, error) {
+	q := FeedbackQuery{Oldest: true, Limit: -1}
+	if t, err := time.ParseInLocation("2006-01-02", f.From, time.Local); err == nil {
+		q.Since = t
+	}
+	if t, err := time.ParseInLocation("2006-01-02", f.To, time.Local); err == nil {
+		q.Until = t.AddDate(0, 0, 1)
+	}
+	if f.Moderation != "" {
+		q.Moderation = []string{f.Moderation}
+	}
+
+	list, err := store.ListFeedback(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	d := &exportData{}
+	for _, it := range list {
+		if f.PublicOnly && !it.IsPublic {
+			continue
+		}
+		d.Feedback = append(d.Feedback, it)
+		replies, err := store.RepliesByFeedbackID(ctx, it.ID)
+		if err != nil {
+			return nil, err
+		}
+		d.Replies = append(d.Replies, replies...)
+	}
+
+	if f.isZero() {
+		d.Users, err = store.ListUsers(ctx)
+		return d, err
+	}
+	seen := map[string]bool{}
+	addUser := func(id string) error {
+		if id == "" || seen[id] {
+			return nil
+		}
+		seen[id] = true
+		u, err := store.UserByID(ctx, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		d.Users = append(d.Users, *u)
+		return nil
+	}
+	for _, it := range d.Feedback {
+		if err := addUser(it.UserID); err != nil {
+			return nil, err
+		}
+	}
+	for _, it := range d.Replies {
+		if err := addUser(it.AdminUserID); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// writeJSONL 按用户、反馈、回复的顺序输出，导入时被引用的一方总在前面。
+func writeJSONL(w io.Writer, d *exportData) error {
+	enc := json.NewEncoder(w)
+	for _, u := range d.Users {
+		if err := enc.Encode(userRecord(u)); err != nil {
+			return err
+		}
+	}
+	for _, f := range d.Feedback {
+		if err := enc.Encode(feedbackRecord(f)); err != nil {
+			return err
+		}
+	}
+	for _, r := range d.Replies {
+		if err := enc.Encode(replyRecord(r)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCSV 给分析用，一次只导一种数据；时间用 RFC 3339，方便表格软件识别。
+// 标题、正文、用户名都是用户填的，每个格子都过一遍 csvCell，防止被表格软件当成公式执行。
+func writeCSV(w io.Writer, kind string, d *exportData) error {
+	cw := csv.NewWriter(w)
+	ts := func(t time.Time) string { return t.Format(time.RFC3339) }
+	row := func(cells ...string) {
+		for i := range cells {
+			cells[i] = csvCell(cells[i])
+		}
+		_ = cw.Write(cells)
+	}
+	switch kind {
+	case exportTypeUser:
+		row("id", "linux_do_id", "username", "avatar_url", "created_at")
+		for _, u := range d.Users {
+			row(u.ID, u.LinuxDoID, u.Username, u.AvatarURL, ts(u.CreatedAt))
+		}
+	case exportTypeReply:
+		row("id", "feedback_id", "admin_user_id", "admin_username", "content", "created_at", "hidden")
+		for _, r := range d.Replies {
+			row(r.ID, r.FeedbackID, r.AdminUserID, r.AdminUsername, r.Content, ts(r.CreatedAt), strconv.FormatBool(r.Hidden))
+		}
+	default:
+		row("id", "title", "content", "is_public", "user_id", "username", "created_at", "updated_at", "moderation", "hidden", "merged_into")
+		for _, f := range d.Feedback {
+			row(f.ID, f.Title, f.Content, strconv.FormatBool(f.IsPublic), f.UserID, f.Username,
+				ts(f.CreatedAt), ts(f.UpdatedAt), f.Moderation, strconv.FormatBool(f.Hidden), f.MergedInto)
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvCell 给以 = + - @ 或制表符、回车开头的格子前面加一个单引号，
+// Excel / LibreOffice 会把它当成普通文本，而不是公式（CSV 注入）。
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+type ImportCount struct {
+	Type      string
+	Inserted  int
+	Unchanged int
+	Conflicts int
+}
+
+type ImportConflict struct {
+	Line   int
+	Type   string
+	ID     string
+	Reason string
+}
+
+// ImportReport 是一次导入（或试运行）的结果。
+type ImportReport struct {
+	DryRun    bool
+	Lines     int
+	Counts    []ImportCount // 依次是 user / feedback / reply
+	Conflicts []ImportConflict
+}
+
+func (r *ImportReport) count(typ string) *ImportCount {
+	for i := range r.Counts {
+		if r.Counts[i].Type == typ {
+			return &r.Counts[i]
+		}
+	}
+	r.Counts = append(r.Counts, ImportCount{Type: typ})
+	return &r.Counts[len(r.Counts)-1]
+}
+
+func (r *ImportReport) conflict(line int, typ, id, reason string) {
+	if typ != exportTypeUser && typ != exportTypeFeedback && typ != exportTypeReply {
+		typ = "?"
+	}
+	r.count(typ).Conflicts++
+	r.Conflicts = append(r.Conflicts, ImportConflict{Line: line, Type: typ, ID: id, Reason: reason})
+}
+
+// importJSONL 读入 writeJSONL 的输出。只插入库里没有的 ID：已有且内容一致的算「未变化」，
+// 已有但内容不同、或者引用的作者 / 反馈不存在的记为冲突，不覆盖。所以同一份文件可以反复导入。
+// 先把整份文件检查一遍、攒下要插入的记录，最后在一个事务里写入（Store.ImportData），
+// 中途出错什么都不会写进去。dryRun 时只检查不写库。
+func importJSONL(ctx context.Context, store Store, r io.Reader, dryRun bool) (*ImportReport, error) {
+	rep := &ImportReport{DryRun: dryRun}
+	for _, t := range []string{exportTypeUser, exportTypeFeedback, exportTypeReply} {
+		rep.count(t)
+	}
+	// 前面几行「将要插入」的记录还没进库，要记下来，后面的引用检查和重复检查才准。
+	pendingUsers := map[string]bool{}
+	pendingLinuxDo := map[string]bool{}
+	pendingFeedback := map[string]bool{}
+	pendingReplies := map[string]bool{}
+	batch := &exportData{}
+
+	userExists := func(id string) (bool, error) {
+		if pendingUsers[id] {
+			return true, nil
+		}
+		_, err := store.UserByID(ctx, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	feedbackExists := func(id string) (bool, error) {
+		if pendingFeedback[id] {
+			return true, nil
+		}
+		_, err := store.FeedbackByID(ctx, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 4<<20)
+	for line := 1; sc.Scan(); line++ {
+		raw := strings.TrimSpace(sc.Text())
+		if raw == "" {
+			continue
+		}
+		rep.Lines++
+
+		var rec exportRecord
+		if err := json.Unmarshal([]byte(raw), &rec); err != nil {
+			rep.conflict(line, "", "", "JSON 解析失败: "+err.Error())
+			continue
+		}
+		if strings.TrimSpace(rec.ID) == "" {
+			rep.conflict(line, rec.Type, "", "缺少 id")
+			continue
+		}
+
+		switch rec.Type {
+		case exportTypeUser:
+			if rec.LinuxDoID == "" || rec.Username == "" {
+				rep.conflict(line, rec.Type, rec.ID, "缺少 linux_do_id 或 username")
+				continue
+			}
+			if pendingUsers[rec.ID] || pendingLinuxDo[rec.LinuxDoID] {
+				rep.conflict(line, rec.Type, rec.ID, "文件里前面已有同一个 id 或 linux_do_id")
+				continue
+			}
+			cur, err := store.UserByID(ctx, rec.ID)
+			if err == nil {
+				if userRecord(*cur) == rec {
+					rep.count(rec.Type).Unchanged++
+				} else {
+					rep.conflict(line, rec.Type, rec.ID, "ID 已存在且内容不同，未覆盖")
+				}
+				continue
+			}
+			if !errors.Is(err, sql.ErrNoRows) {
+				return nil, err
+			}
+			other, err := store.UserByLinuxDoID(ctx, rec.LinuxDoID)
+			if err == nil {
+				rep.conflict(line, rec.Type, rec.ID, "linux_do_id 已被用户 "+other.ID+" 占用")
+				continue
+			}
+			if !errors.Is(err, sql.ErrNoRows) {
+				return nil, err
+			}
+			batch.Users = append(batch.Users, User{
+				ID: rec.ID, LinuxDoID: rec.LinuxDoID, Username: rec.Username, AvatarURL: rec.AvatarURL, CreatedAt: time.Unix(rec.CreatedAt, 0),
+			})
+			pendingUsers[rec.ID] = true
+			pendingLinuxDo[rec.LinuxDoID] = true
+			rep.count(rec.Type).Inserted++
+
+		case exportTypeFeedback:
+			if rec.Title == "" || rec.UserID == "" {
+				rep.conflict(line, rec.Type, rec.ID, "缺少 title 或 user_id")
+				continue
+			}
+			if rec.Moderation == "" {
+				rec.Moderation = moderationPublished
+			}
+			if rec.UpdatedAt == 0 {
+				rec.UpdatedAt = rec.CreatedAt
+			}
+			if pendingFeedback[rec.ID] {
+				rep.conflict(line, rec.Type, rec.ID, "文件里前面已有同一个 id")
+				continue
+			}
+			cur, err := store.FeedbackByID(ctx, rec.ID)
+			if err == nil {
+				if feedbackRecord(*cur) == rec {
+					rep.count(rec.Type).Unchanged++
+				} else {
+					rep.conflict(line, rec.Type, rec.ID, "ID 已存在且内容不同，未覆盖")
+				}
+				continue
+			}
+			if !errors.Is(err, sql.ErrNoRows) {
+				return nil, err
+			}
+			ok, err := userExists(rec.UserID)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				rep.conflict(line, rec.Type, rec.ID, "作者 "+rec.UserID+" 不存在")
+				continue
+			}
+			batch.Feedback = append(batch.Feedback, Feedback{
+				ID: rec.ID, Title: rec.Title, Content: rec.Content, IsPublic: rec.IsPublic, UserID: rec.UserID,
+				CreatedAt: time.Unix(rec.CreatedAt, 0), UpdatedAt: time.Unix(rec.UpdatedAt, 0),
+				Moderation: rec.Moderation, ModerationNote: rec.ModerationNote, RejectReason: rec.RejectReason,
+				Hidden: rec.Hidden, MergedInto: rec.MergedInto,
+			})
+			pendingFeedback[rec.ID] = true
+			rep.count(rec.Type).Inserted++
+
+		case exportTypeReply:
+			if rec.FeedbackID == "" || rec.Content == "" {
+				rep.conflict(line, rec.Type, rec.ID, "缺少 feedback_id 或 content")
+				continue
+			}
+			if pendingReplies[rec.ID] {
+				rep.conflict(line, rec.Type, rec.ID, "文件里前面已有同一个 id")
+				continue
+			}
+			cur, err := store.ReplyByID(ctx, rec.ID)
+			if err == nil {
+				if replyRecord(*cur) == rec {
+					rep.count(rec.Type).Unchanged++
+				} else {
+					rep.conflict(line, rec.Type, rec.ID, "ID 已存在且内容不同，未覆盖")
+				}
+				continue
+			}
+			if !errors.Is(err, sql.ErrNoRows) {
+				return nil, err
+			}
+			ok, err := feedbackExists(rec.FeedbackID)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				rep.conflict(line, rec.Type, rec.ID, "所属反馈 "+rec.FeedbackID+" 不存在")
+				continue
+			}
+			if rec.AdminUserID != "" {
+				ok, err := userExists(rec.AdminUserID)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					rep.conflict(line, rec.Type, rec.ID, "回复人 "+rec.AdminUserID+" 不存在")
+					continue
+				}
+			}
+			batch.Replies = append(batch.Replies, Reply{
+				ID: rec.ID, Content: rec.Content, CreatedAt: time.Unix(rec.CreatedAt, 0),
+				FeedbackID: rec.FeedbackID, AdminUserID: rec.AdminUserID, Hidden: rec.Hidden,
+			})
+			pendingReplies[rec.ID] = true
+			rep.count(rec.Type).Inserted++
+
+		default:
+			rep.conflict(line, rec.Type, rec.ID, fmt.Sprintf("未知类型 %q", rec.Type))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if !dryRun {
+		if err := store.ImportData(ctx, batch); err != nil {
+			return nil, err
+		}
+	}
+	return rep, nil
+}
+
+func (a *App) handleAdminData(w http.ResponseWriter, r *http.Request) {
+	sess := a.readSession(r)
+	if !sess.IsAdmin {
+		http.NotFound(w, r)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+	user := a.sessionUser(ctx, sess)
+
+	a.render(w, r, "admin_data.html", ViewData{
+		Title:    "数据导入导出",
+		Session:  sess,
+		User:     user,
+		IsAuthed: sess.UID != "",
+	})
+}
+
+func (a *App) handleAdminExport(w http.ResponseWriter, r *http.Request) {
+	sess := a.readSession(r)
+	if !sess.IsAdmin {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+	defer cancel()
+
+	filter := exportFilterFromRequest(r)
+	d, err := collectExport(ctx, a.store, filter)
+	if err != nil {
+		slog.ErrorContext(ctx, "查询失败", "err", err)
+		http.Error(w, "查询失败", http.StatusInternalServerError)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	kind := r.URL.Query().Get("type")
+	a.audit(ctx, r, sess, auditDataExport, "data", "", nil, map[string]any{
+		"format": format, "type": kind, "filter": filter,
+		"users": len(d.Users), "feedback": len(d.Feedback), "replies": len(d.Replies),
+	})
+
+	name := "feedback-export-" + time.Now().Format("20060102-150405")
+	if format == "csv" {
+		if kind != exportTypeUser && kind != exportTypeReply {
+			kind = exportTypeFeedback
+		}
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+"-"+kind+`.csv"`)
+		if err := writeCSV(w, kind, d); err != nil {
+			slog.WarnContext(ctx, "写出 CSV 导出失败", "err", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.jsonl"`)
+	if err := writeJSONL(w, d); err != nil {
+		slog.WarnContext(ctx, "写出 JSONL 导出失败", "err", err)
+	}
+}
+
+func (a *App) handleAdminImport(w http.ResponseWriter, r *http.Request) {
+	sess := a.readSession(r)
+	if !sess.IsAdmin {
+		http.NotFound(w, r)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 256<<20)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		a.renderError(w, r, http.StatusBadRequest, "上传失败：文件太大或表单不合法")
+		return
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		a.renderError(w, r, http.StatusBadRequest, "请选择要导入的 JSONL 文件")
+		return
+	}
+	defer file.Close()
+	dryRun := r.FormValue("dry_run") == "1"
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+	user := a.sessionUser(ctx, sess)
+
+	rep, err := importJSONL(ctx, a.store, file, dryRun)
+	if err != nil {
+		a.serverError(w, r, "导入中断，没有写入任何数据："+err.Error(), err)
+		return
+	}
+	if !dryRun {
+		a.audit(ctx, r, sess, auditDataImport, "data", "", nil, map[string]any{
+			"lines": rep.Lines, "counts": rep.Counts, "conflicts": len(rep.Conflicts),
+		})
+	}
+
+	a.render(w, r, "admin_data.html", ViewData{
+		Title:    "数据导入导出",
+		Session:  sess,
+		User:     user,
+		IsAuthed: sess.UID != "",
+		Import:   rep,
+	})
+}
+
+// runExportCommand 实现 `feedback export <dest.jsonl>`：整库导出，用于迁移到另一个实例。
+func runExportCommand(cfg Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New("用法: feedback export <目标文件.jsonl>")
+	}
+	store, db, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	d, err := collectExport(ctx, store, ExportFilter{})
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(out)
+	if err := writeJSONL(bw, d); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("已导出 %d 个用户、%d 条反馈、%d 条回复到 %s\n", len(d.Users), len(d.Feedback), len(d.Replies), args[0])
+	return nil
+}
+
+// runImportCommand 实现 `feedback import [-dry-run] <src.jsonl>`。
+func runImportCommand(cfg Config, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "只检查，不写入")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("用法: feedback import [-dry-run] <文件.jsonl>")
+	}
+
+	in, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	store, db, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	rep, err := importJSONL(ctx, store, in, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	if rep.DryRun {
+		fmt.Println("试运行，未写入任何数据。")
+	}
+	for _, c := range rep.Counts {
+		fmt.Printf("%-8s 新增 %d，未变化 %d，冲突 %d\n", c.Type, c.Inserted, c.Unchanged, c.Conflicts)
+	}
+	for _, c := range rep.Conflicts {
+		fmt.Printf("第 %d 行 %s %s: %s\n", c.Line, c.Type, c.ID, c.Reason)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"strings"
+	"testing"
+)
+
+func TestWriteCSVEscapesFormulas(t *testing.T) {
+	d := &exportData{Feedback: []Feedback{{
+		ID: "f1", Title: "=HYPERLINK(\"http://evil\")", Content: "+1", Username: "@bob", CreatedAt: testEpoch, UpdatedAt: testEpoch,
+	}, {
+		ID: "f2", Title: "-2", Content: "\tx", Username: "alice", CreatedAt: testEpoch, UpdatedAt: testEpoch,
+	}}}
+	var buf bytes.Buffer
+	if err := writeCSV(&buf, exportTypeFeedback, d); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"f1", `'=HYPERLINK("http://evil")`, "'+1", "'@bob"},
+		{"f2", "'-2", "'\tx", "alice"},
+	}
+	for i, w := range want {
+		r := rows[i+1]
+		if got := []string{r[0], r[1], r[2], r[5]}; strings.Join(got, "|") != strings.Join(w, "|") {
+			t.Errorf("row %d = %q, want %q", i+1, got, w)
+		}
+	}
+}
+
+func TestImportJSONLIsAtomic(t *testing.T) {
+	ctx := context.Background()
+	s := openTestSQLite(t)
+	// 用触发器让 u2 写入失败，模拟写到一半出错：整批回滚，前面的 u1、f1 也不能留下。
+	in := strings.Join([]string{
+		`{"type":"user","id":"u1","linux_do_id":"ld-1","username":"one","created_at":1}`,
+		`{"type":"feedback","id":"f1","user_id":"u1","title":"t","content":"c","created_at":1}`,
+		`{"type":"user","id":"u2","linux_do_id":"ld-2","username":"two","created_at":1}`,
+	}, "\n")
+	if _, err := s.db.ExecContext(ctx, `CREATE TRIGGER fail_u2 BEFORE INSERT ON users WHEN NEW.id = 'u2'
+		BEGIN SELECT RAISE(ABORT, 'boom'); END`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := importJSONL(ctx, s, strings.NewReader(in), false); err == nil {
+		t.Fatal("import succeeded, want error")
+	}
+	if n, _ := s.CountFeedback(ctx, FeedbackQuery{}); n != 0 {
+		t.Fatalf("partial import left %d feedback", n)
+	}
+	if users, _ := s.ListUsers(ctx); len(users) != 0 {
+		t.Fatalf("partial import left %d users", len(users))
+	}
+
+	if _, err := s.db.ExecContext(ctx, `DROP TRIGGER fail_u2`); err != nil {
+		t.Fatal(err)
+	}
+	rep, err := importJSONL(ctx, s, strings.NewReader(in+"\n"+`{"type":"user","id":"u3","linux_do_id":"ld-1","username":"dup","created_at":1}`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := rep.Counts[0]; c.Inserted != 2 || c.Conflicts != 1 {
+		t.Fatalf("user counts = %+v, want 2 inserted and 1 conflict", c)
+	}
+	if rep, err := importJSONL(ctx, s, strings.NewReader(in), false); err != nil || rep.Counts[0].Unchanged != 2 || rep.Counts[1].Unchanged != 1 {
+		t.Fatalf("re-import = %+v, %v", rep, err)
+	}
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
@@ -13,10 +15,12 @@ func (a *App) handleHome(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 
 	sess := a.readSession(r)
-	user, _ := a.userByID(ctx, sess.UID)
+	user := a.sessionUser(ctx, sess)
 
-	var cnt int64
-	_ = a.db.QueryRowContext(ctx, `SELECT COUNT(1) FROM feedbacks WHERE is_public = 1`).Scan(&cnt)
+	cnt, err := a.store.CountFeedback(ctx, FeedbackQuery{Square: true})
+	if err != nil {
+		slog.ErrorContext(ctx, "统计公开反馈数失败", "err", err)
+	}
 
 	a.render(w, r, "home.html", ViewData{
 		Title:       "反馈站",
@@ -36,7 +40,7 @@ func (a *App) handleAdminPage(w http.ResponseWriter, r *http.Request) {
 	sess := a.readSession(r)
 	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
 	defer cancel()
-	user, _ := a.userByID(ctx, sess.UID)
+	user := a.sessionUser(ctx, sess)
 
 	a.render(w, r, "admin.html", ViewData{
 		Title:    "管理员",
@@ -52,13 +56,16 @@ func (a *App) handleAdminLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	key := strings.TrimSpace(r.FormValue("key"))
+	old := a.readSession(r)
 	if key == "" || key != a.cfg.AdminKey {
+		a.audit(r.Context(), r, old, auditAdminLoginFailed, "session", "", nil, nil)
 		http.Redirect(w, r, "/admin?bad=1", http.StatusFound)
 		return
 	}
 
-	old := a.readSession(r)
 	a.writeSession(w, r, Session{UID: old.UID, IsAdmin: true})
+	a.audit(r.Context(), r, old, auditAdminLogin, "session", "",
+		map[string]any{"is_admin": old.IsAdmin}, map[string]any{"is_admin": true})
 	http.Redirect(w, r, "/admin?ok=1", http.StatusFound)
 }
 
@@ -67,44 +74,19 @@ func (a *App) handleSquare(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 
 	sess := a.readSession(r)
-	user, _ := a.userByID(ctx, sess.UID)
+	user := a.sessionUser(ctx, sess)
 
 	q := strings.TrimSpace(r.URL.Query().Get("q"))
 
-	where := `WHERE f.is_public = 1`
-	args := []any{}
-	if q != "" {
-		where += ` AND (f.title LIKE ? OR f.content LIKE ?)`
-		like := "%" + q + "%"
-		args = append(args, like, like)
-	}
-
-	rows, err := a.db.QueryContext(ctx, `
-		SELECT f.id, f.title, f.content, f.is_public, f.user_id, u.username, f.created_at, f.updated_at
-		FROM feedbacks f
-		JOIN users u ON u.id = f.user_id
-	`+where+`
-		ORDER BY f.created_at DESC
-		LIMIT 50
-	`, args...)
+	list, err := a.store.ListFeedback(ctx, FeedbackQuery{Square: true, Search: q, Limit: 50})
 	if err != nil {
-		a.renderError(w, r, http.StatusInternalServerError, "查询失败")
+		a.serverError(w, r, "查询失败", err)
 		return
 	}
-	defer rows.Close()
-
-	var list []Feedback
-	for rows.Next() {
-		var f Feedback
-		var isPublic int64
-		var created, updated int64
-		if err := rows.Scan(&f.ID, &f.Title, &f.Content, &isPublic, &f.UserID, &f.Username, &created, &updated); err != nil {
-			continue
+	if q != "" {
+		for i := range list {
+			list[i].Excerpt = searchExcerpt(list[i], q)
 		}
-		f.IsPublic = isPublic == 1
-		f.CreatedAt = time.Unix(created, 0)
-		f.UpdatedAt = time.Unix(updated, 0)
-		list = append(list, f)
 	}
 
 	a.render(w, r, "square.html", ViewData{
@@ -127,29 +109,92 @@ func (a *App) handleSquareDetail(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
-	sess := a.readSession(r)
-	user, _ := a.userByID(ctx, sess.UID)
+	if d, ok := a.detailData(ctx, w, r, a.readSession(r), id); ok {
+		a.render(w, r, "detail.html", d)
+	}
+}
+
+// detailData 准备详情页要显示的内容。看不到、不存在或者要跳转时已经写好了响应，返回 false。
+// 回复表单的预览也要重新渲染整个详情页，所以单独拆出来。
+func (a *App) detailData(ctx context.Context, w http.ResponseWriter, r *http.Request, sess Session, id string) (ViewData, bool) {
+	user := a.sessionUser(ctx, sess)
 
-	item, err := a.feedbackByID(ctx, id)
+	item, err := a.store.FeedbackByID(ctx, id)
 	if err == sql.ErrNoRows {
 		http.NotFound(w, r)
-		return
+		return ViewData{}, false
 	}
 	if err != nil {
-		a.renderError(w, r, http.StatusInternalServerError, "查询失败")
-		return
+		a.serverError(w, r, "查询失败", err)
+		return ViewData{}, false
 	}
 
-	canSee := item.IsPublic || sess.IsAdmin || (sess.UID != "" && sess.UID == item.UserID)
+	canSee := item.canView(sess)
 	if !canSee {
 		http.NotFound(w, r)
-		return
+		return ViewData{}, false
 	}
 
-	replies, _ := a.repliesByFeedbackID(ctx, id)
+	// 被合并的反馈：访客直接跳到目标，作者和管理员留在原页看提示。
+	var mergedTarget *Feedback
+	if item.MergedInto != "" {
+		if !sess.IsAdmin && sess.UID != item.UserID {
+			http.Redirect(w, r, "/square/"+item.MergedInto, http.StatusFound)
+			return ViewData{}, false
+		}
+		var err error
+		mergedTarget, err = a.store.FeedbackByID(ctx, item.MergedInto)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			slog.ErrorContext(ctx, "查询合并目标失败", "feedback", item.ID, "target", item.MergedInto, "err", err)
+		}
+	}
+	var similar []Feedback
+	if sess.IsAdmin && item.MergedInto == "" {
+		var err error
+		similar, err = a.similarFeedback(ctx, item.Title, item.ID, 5)
+		if err != nil {
+			slog.ErrorContext(ctx, "查询相似反馈失败", "feedback", item.ID, "err", err)
+		}
+	}
+
+	replies, err := a.store.RepliesByFeedbackID(ctx, id)
+	if err != nil {
+		slog.ErrorContext(ctx, "查询回复失败", "feedback", id, "err", err)
+	}
+	if !sess.IsAdmin {
+		// 被隐藏的回复对普通访客只留一个占位
+		for i := range replies {
+			if replies[i].Hidden {
+				replies[i].Content = ""
+			}
+		}
+	}
 	replyErr := r.URL.Query().Get("reply_error") == "1"
 
-	a.render(w, r, "detail.html", ViewData{
+	// 管理员的回复框里可以插入回复模板，占位符先按这条反馈替换好。
+	var templates []ReplyTemplate
+	if sess.IsAdmin {
+		if templates, err = a.replyTemplates(ctx); err != nil {
+			slog.ErrorContext(ctx, "查询回复模板失败", "err", err)
+		}
+		for i := range templates {
+			templates[i].Content = a.expandReplyTemplate(templates[i].Content, item)
+		}
+	}
+
+	var watching bool
+	if sess.UID != "" {
+		if watching, err = a.store.IsWatching(ctx, id, sess.UID); err != nil {
+			slog.ErrorContext(ctx, "查询关注状态失败", "feedback", id, "err", err)
+		}
+		if watching {
+			if err := a.store.MarkWatchRead(ctx, id, sess.UID); err != nil {
+				slog.ErrorContext(ctx, "清除未读动态失败", "feedback", id, "err", err)
+			}
+		}
+	}
+
+	return ViewData{
 		Title:      item.Title,
 		Session:    sess,
 		User:       user,
@@ -158,7 +203,15 @@ func (a *App) handleSquareDetail(w http.ResponseWriter, r *http.Request) {
 		Item:       item,
 		Replies:    replies,
 		FlashError: func() string { if replyErr { return "回复提交失败：内容不能为空且长度需合理。" }; return "" }(),
-	})
+
+		FlashInfo:     detailFlash(r.URL.Query()),
+		ReportReasons: reportReasons,
+		Similar:       similar,
+		MergedTarget:  mergedTarget,
+		Watching:      watching,
+
+		ReplyTemplates: templates,
+	}, true
 }
 
 func (a *App) handleCreateReply(w http.ResponseWriter, r *http.Request) {
@@ -172,6 +225,11 @@ func (a *App) handleCreateReply(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	// 回复必须能落到具体的人头上，仅凭管理员密钥不够。
+	if sess.UID == "" {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
 
 	if err := r.ParseForm(); err != nil {
 		http.Redirect(w, r, "/square/"+id+"?reply_error=1", http.StatusFound)
@@ -179,34 +237,98 @@ func (a *App) handleCreateReply(w http.ResponseWriter, r *http.Request) {
 	}
 
 	content := strings.TrimSpace(r.FormValue("content"))
-	if content == "" || len(content) > 20000 {
+	status := r.FormValue("status")
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	// 没有脚本时「预览」按钮也提交到这里：不保存，带着草稿和渲染结果重新显示详情页。
+	if r.FormValue("preview") != "" {
+		d, ok := a.detailData(ctx, w, r, sess, id)
+		if !ok {
+			return
+		}
+		d.FormContent, d.FormStatus, d.Previewing, d.Preview = content, status, true, a.previewMarkdown(ctx, content)
+		a.render(w, r, "detail.html", d)
+		return
+	}
+
+	// 「插入」回复模板同理：把模板接在已经写的内容后面，模板带了状态就一起选上。
+	if r.FormValue("insert") != "" {
+		d, ok := a.detailData(ctx, w, r, sess, id)
+		if !ok {
+			return
+		}
+		d.FormContent, d.FormStatus = content, status
+		if t, err := a.replyTemplateByID(ctx, r.FormValue("template")); err == nil {
+			if d.FormContent != "" {
+				d.FormContent += "\n\n"
+			}
+			d.FormContent += a.expandReplyTemplate(t.Content, d.Item)
+			if t.Status != "" {
+				d.FormStatus = t.Status
+			}
+		}
+		a.render(w, r, "detail.html", d)
+		return
+	}
+
+	if content == "" || len(content) > maxContentLen || !validReplyStatus(status) {
 		http.Redirect(w, r, "/square/"+id+"?reply_error=1", http.StatusFound)
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-	defer cancel()
+	if a.accountGone(ctx, sess.UID) {
+		a.clearSession(w)
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
 
 	// 权限：如果反馈不存在/不可见，直接 404
-	item, err := a.feedbackByID(ctx, id)
+	item, err := a.store.FeedbackByID(ctx, id)
 	if err != nil {
 		http.NotFound(w, r)
 		return
 	}
-	if !item.IsPublic && !(sess.UID != "" && sess.UID == item.UserID) && !sess.IsAdmin {
+	if !item.canView(sess) {
 		http.NotFound(w, r)
 		return
 	}
 
-	adminUserID := nullIfEmpty(sess.UID)
-	_, err = a.db.ExecContext(ctx,
-		`INSERT INTO replies(id, content, created_at, feedback_id, admin_user_id) VALUES(?,?,?,?,?)`,
-		newID(), content, time.Now().Unix(), id, adminUserID,
-	)
-	if err != nil {
+	reply := &Reply{
+		ID:          newID(),
+		Content:     content,
+		CreatedAt:   time.Now(),
+		FeedbackID:  id,
+		AdminUserID: sess.UID,
+	}
+	if err := a.store.CreateReply(ctx, reply); err != nil {
 		http.Redirect(w, r, "/square/"+id+"?reply_error=1", http.StatusFound)
 		return
 	}
+	appMetrics.repliesCreated.inc()
+	a.audit(ctx, r, sess, auditReplyCreate, "reply", reply.ID, nil, map[string]any{
+		"feedback_id": id,
+		"content":     content,
+		"created_at":  reply.CreatedAt.Unix(),
+	})
+	a.autoWatch(ctx, id, sess.UID)
+	a.notifyWatchers(ctx, id, sess.UID, "reply")
+
+	// 回复时顺带改审核状态；拒绝的话，回复内容就是给作者看的拒绝原因。
+	if status != "" && status != item.Moderation {
+		reason := ""
+		if status == moderationRejected {
+			reason = truncateRunes(plainText(content), 150)
+			if reason == "" {
+				reason = "见管理员回复"
+			}
+		}
+		if err := a.setModeration(ctx, r, sess, item, status, reason); err != nil {
+			a.serverError(w, r, "回复已发送，但修改审核状态失败", err)
+			return
+		}
+	}
 
 	http.Redirect(w, r, "/square/"+id, http.StatusFound)
 }
@@ -219,13 +341,14 @@ func (a *App) handleNewFeedbackForm(w http.ResponseWriter, r *http.Request) {
 	}
 	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
 	defer cancel()
-	user, _ := a.userByID(ctx, sess.UID)
+	user := a.sessionUser(ctx, sess)
 
 	a.render(w, r, "new.html", ViewData{
-		Title:    "写反馈",
-		Session:  sess,
-		User:     user,
-		IsAuthed: true,
+		Title:           "写反馈",
+		Session:         sess,
+		User:            user,
+		IsAuthed:        true,
+		RequireApproval: a.cfg.RequireApproval,
 	})
 }
 
@@ -245,7 +368,24 @@ func (a *App) handleCreateFeedback(w http.ResponseWriter, r *http.Request) {
 	content := strings.TrimSpace(r.FormValue("content"))
 	isPublic := strings.TrimSpace(r.FormValue("is_public")) != "0"
 
-	if title == "" || len(title) > 200 || content == "" || len(content) > 20000 {
+	if r.FormValue("preview") != "" {
+		user := a.sessionUser(r.Context(), sess)
+		a.render(w, r, "new.html", ViewData{
+			Title:           "写反馈",
+			Session:         sess,
+			User:            user,
+			IsAuthed:        true,
+			RequireApproval: a.cfg.RequireApproval,
+			FormTitle:       title,
+			FormContent:     content,
+			FormIsPrivate:   !isPublic,
+			Previewing:      true,
+			Preview:         a.previewMarkdown(r.Context(), content),
+		})
+		return
+	}
+
+	if title == "" || len(title) > 200 || content == "" || len(content) > maxContentLen {
 		a.renderError(w, r, http.StatusBadRequest, "标题/内容长度不合法")
 		return
 	}
@@ -253,18 +393,74 @@ func (a *App) handleCreateFeedback(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
-	now := time.Now().Unix()
-	id := newID()
-	_, err := a.db.ExecContext(ctx,
-		`INSERT INTO feedbacks(id, title, content, is_public, created_at, updated_at, user_id) VALUES(?,?,?,?,?,?,?)`,
-		id, title, content, boolToInt(isPublic), now, now, sess.UID,
-	)
+	if a.accountGone(ctx, sess.UID) {
+		a.clearSession(w)
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	// 先提示一下可能已经有人提过；用户确认后带 ignore_similar=1 再提交。
+	if r.FormValue("ignore_similar") != "1" {
+		similar, err := a.similarFeedback(ctx, title, "", 5)
+		if err != nil {
+			// 相似提示只是锦上添花，查不出来就直接往下提交。
+			slog.ErrorContext(ctx, "查询相似反馈失败", "err", err)
+		}
+		if len(similar) > 0 {
+			user := a.sessionUser(ctx, sess)
+			a.render(w, r, "new.html", ViewData{
+				Title:           "写反馈",
+				Session:         sess,
+				User:            user,
+				IsAuthed:        true,
+				RequireApproval: a.cfg.RequireApproval,
+				FormTitle:       title,
+				FormContent:     content,
+				FormIsPrivate:   !isPublic,
+				Similar:         similar,
+			})
+			return
+		}
+	}
+
+	check, err := a.runContentChecks(ctx, contentDraft{UserID: sess.UID, Title: title, Content: content, IsPublic: isPublic})
 	if err != nil {
-		a.renderError(w, r, http.StatusInternalServerError, "写入失败")
+		a.serverError(w, r, "内容检查失败", err)
+		return
+	}
+	if check.Action == checkReject {
+		a.renderError(w, r, check.Status, check.Reason)
+		return
+	}
+	moderation, note := moderationPublished, ""
+	switch {
+	case check.Action == checkFlag:
+		moderation, note = moderationFlagged, check.Reason
+	case isPublic && a.cfg.RequireApproval:
+		moderation = moderationPending
+	}
+
+	now := time.Now()
+	item := &Feedback{
+		ID:             newID(),
+		Title:          title,
+		Content:        content,
+		IsPublic:       isPublic,
+		UserID:         sess.UID,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		Moderation:     moderation,
+		ModerationNote: note,
+	}
+	if err := a.store.CreateFeedback(ctx, item); err != nil {
+		a.serverError(w, r, "写入失败", err)
 		return
 	}
 
-	http.Redirect(w, r, "/square/"+id, http.StatusFound)
+	appMetrics.feedbackCreated.inc(moderation)
+	a.autoWatch(ctx, item.ID, sess.UID)
+
+	http.Redirect(w, r, "/square/"+item.ID, http.StatusFound)
 }
 
 func (a *App) handleMyFeedback(w http.ResponseWriter, r *http.Request) {
@@ -277,34 +473,30 @@ func (a *App) handleMyFeedback(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
-	user, _ := a.userByID(ctx, sess.UID)
+	user := a.sessionUser(ctx, sess)
 
-	rows, err := a.db.QueryContext(ctx, `
-		SELECT f.id, f.title, f.content, f.is_public, f.user_id, u.username, f.created_at, f.updated_at
-		FROM feedbacks f
-		JOIN users u ON u.id = f.user_id
-		WHERE f.user_id = ?
-		ORDER BY f.created_at DESC
-		LIMIT 100
-	`, sess.UID)
+	list, err := a.store.ListFeedback(ctx, FeedbackQuery{UserID: sess.UID, Limit: 100})
 	if err != nil {
-		a.renderError(w, r, http.StatusInternalServerError, "查询失败")
+		a.serverError(w, r, "查询失败", err)
 		return
 	}
-	defer rows.Close()
 
-	var list []Feedback
-	for rows.Next() {
-		var f Feedback
-		var isPublic int64
-		var created, updated int64
-		if err := rows.Scan(&f.ID, &f.Title, &f.Content, &isPublic, &f.UserID, &f.Username, &created, &updated); err != nil {
-			continue
+	// 关注的反馈里去掉自己写的（上面已经列出来了）、已经看不到的（转成私有、被隐藏等），
+	// 以及被合并掉的（合并时关注已经复制到目标反馈上）。
+	all, err := a.store.ListFeedback(ctx, FeedbackQuery{WatchedBy: sess.UID, Limit: 100})
+	if err != nil {
+		a.serverError(w, r, "查询失败", err)
+		return
+	}
+	var watched []Feedback
+	for _, f := range all {
+		if f.UserID != sess.UID && f.MergedInto == "" && f.canView(sess) {
+			watched = append(watched, f)
 		}
-		f.IsPublic = isPublic == 1
-		f.CreatedAt = time.Unix(created, 0)
-		f.UpdatedAt = time.Unix(updated, 0)
-		list = append(list, f)
+	}
+	unread, err := a.store.UnreadWatches(ctx, sess.UID)
+	if err != nil {
+		slog.ErrorContext(ctx, "查询未读动态失败", "err", err)
 	}
 
 	a.render(w, r, "me.html", ViewData{
@@ -313,75 +505,11 @@ func (a *App) handleMyFeedback(w http.ResponseWriter, r *http.Request) {
 		User:     user,
 		IsAuthed: true,
 		Feedback: list,
+		Watched:  watched,
+		Unread:   unread,
 	})
 }
 
-func (a *App) userByID(ctx context.Context, id string) (*User, error) {
-	if strings.TrimSpace(id) == "" {
-		return nil, sql.ErrNoRows
-	}
-	var u User
-	var avatar sql.NullString
-	var created int64
-	err := a.db.QueryRowContext(ctx,
-		`SELECT id, linux_do_id, username, avatar_url, created_at FROM users WHERE id = ?`,
-		id,
-	).Scan(&u.ID, &u.LinuxDoID, &u.Username, &avatar, &created)
-	if err != nil {
-		return nil, err
-	}
-	if avatar.Valid {
-		u.AvatarURL = avatar.String
-	}
-	u.CreatedAt = time.Unix(created, 0)
-	return &u, nil
-}
-
-func (a *App) feedbackByID(ctx context.Context, id string) (*Feedback, error) {
-	var f Feedback
-	var isPublic int64
-	var created, updated int64
-	err := a.db.QueryRowContext(ctx, `
-		SELECT f.id, f.title, f.content, f.is_public, f.user_id, u.username, f.created_at, f.updated_at
-		FROM feedbacks f
-		JOIN users u ON u.id = f.user_id
-		WHERE f.id = ?
-	`, id).Scan(&f.ID, &f.Title, &f.Content, &isPublic, &f.UserID, &f.Username, &created, &updated)
-	if err != nil {
-		return nil, err
-	}
-	f.IsPublic = isPublic == 1
-	f.CreatedAt = time.Unix(created, 0)
-	f.UpdatedAt = time.Unix(updated, 0)
-	return &f, nil
-}
-
-func (a *App) repliesByFeedbackID(ctx context.Context, feedbackID string) ([]Reply, error) {
-	rows, err := a.db.QueryContext(ctx, `
-		SELECT r.id, r.content, r.created_at, COALESCE(u.username, '')
-		FROM replies r
-		LEFT JOIN users u ON u.id = r.admin_user_id
-		WHERE r.feedback_id = ?
-		ORDER BY r.created_at ASC
-	`, feedbackID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var list []Reply
-	for rows.Next() {
-		var it Reply
-		var created int64
-		if err := rows.Scan(&it.ID, &it.Content, &created, &it.AdminUsername); err != nil {
-			continue
-		}
-		it.CreatedAt = time.Unix(created, 0)
-		list = append(list, it)
-	}
-	return list, nil
-}
-
 func boolToInt(b bool) int64 {
 	if b {
 		return 1
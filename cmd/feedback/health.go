@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// healthState 是探针用到的一点运行时状态。
+type healthState struct {
+	// draining 在收到退出信号后置位，/readyz 立即返回 503，让负载均衡先把流量摘走。
+	draining atomic.Bool
+
+	// Linux DO 的探测结果缓存一会儿，探针几秒一次，没必要每次都去请求外部服务。
+	mu       sync.Mutex
+	oauthAt  time.Time
+	oauthErr error
+}
+
+const oauthProbeTTL = 30 * time.Second
+
+// 每项检查的结果只有 ok / fail / skipped。/readyz 不需要登录，失败原因（连接串、内网地址等）
+// 只写进日志，不放进响应。
+const (
+	checkOK      = "ok"
+	checkFail    = "fail"
+	checkSkipped = "skipped"
+)
+
+// handleHealthz 只说明进程还活着，不碰数据库。
+func (a *App) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, map[string]any{
+		"status":         "ok",
+		"uptime_seconds": int64(time.Since(processStart).Seconds()),
+	})
+}
+
+// handleReadyz 检查数据库连接、表结构版本，以及（配置了 READYZ_CHECK_OAUTH 时）Linux DO 是否可达。
+func (a *App) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	checks := map[string]string{}
+	fail := func(name string, args ...any) {
+		checks[name] = checkFail
+		slog.WarnContext(ctx, "就绪检查失败", append([]any{"check", name}, args...)...)
+	}
+
+	if err := a.db.PingContext(ctx); err != nil {
+		fail("database", "err", err)
+	} else {
+		checks["database"] = checkOK
+	}
+
+	// 库里的版本比代码新也算就绪：表结构只做加法，新版本实例先迁移过不影响旧版本。
+	if v, err := a.db.storedSchemaVersion(ctx); err != nil {
+		fail("migrations", "err", err)
+	} else if v < schemaVersion {
+		fail("migrations", "err", "表结构版本落后", "version", v, "want", schemaVersion)
+	} else {
+		checks["migrations"] = checkOK
+	}
+
+	switch {
+	case !a.cfg.ReadyzCheckOAuth:
+		checks["oauth"] = checkSkipped
+	case !a.cfg.linuxDoEnabled():
+		fail("oauth", "err", a.cfg.validateLinuxDo())
+	default:
+		if err := a.probeOAuth(ctx); err != nil {
+			fail("oauth", "err", err)
+		} else {
+			checks["oauth"] = checkOK
+		}
+	}
+
+	ok := true
+	for _, c := range checks {
+		if c == checkFail {
+			ok = false
+		}
+	}
+	status, code := "ok", http.StatusOK
+	if a.health.draining.Load() {
+		status, code = "draining", http.StatusServiceUnavailable
+	} else if !ok {
+		status, code = "fail", http.StatusServiceUnavailable
+	}
+	writeHealth(w, code, map[string]any{"status": status, "checks": checks})
+}
+
+// probeOAuth 请求一下 token 端点。只要对方回了 HTTP 响应（哪怕是 4xx）就算可达，
+// 这里只关心网络和 DNS 通不通，不发真正的凭据。
+func (a *App) probeOAuth(ctx context.Context) error {
+	h := &a.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.oauthAt.IsZero() && time.Since(h.oauthAt) < oauthProbeTTL {
+		return h.oauthErr
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, a.cfg.LinuxDoTokenURL, nil)
+	if err == nil {
+		var res *http.Response
+		res, err = http.DefaultClient.Do(req)
+		if err == nil {
+			res.Body.Close()
+		}
+	}
+	h.oauthAt, h.oauthErr = time.Now(), err
+	return err
+}
+
+func writeHealth(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// isProbePath 判断是不是探针请求：不打访问日志，免得几秒一条把日志刷满。
+func isProbePath(p string) bool {
+	return p == "/healthz" || p == "/readyz"
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadyz(t *testing.T) {
+	s := openTestSQLite(t)
+	a := &App{db: s.db}
+
+	get := func() (int, string) {
+		w := httptest.NewRecorder()
+		a.handleReadyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return w.Code, w.Body.String()
+	}
+
+	code, body := get()
+	var got struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}
+	if err := json.Unmarshal([]byte(body), &got); err != nil {
+		t.Fatal(err)
+	}
+	if code != http.StatusOK || got.Status != "ok" || got.Checks["database"] != checkOK ||
+		got.Checks["migrations"] != checkOK || got.Checks["oauth"] != checkSkipped {
+		t.Fatalf("healthy readyz = %d %s", code, body)
+	}
+
+	// 失败时只给状态，错误原文进日志。
+	_ = s.db.Close()
+	code, body = get()
+	if code != http.StatusServiceUnavailable || !strings.Contains(body, `"database":"fail"`) {
+		t.Fatalf("closed db readyz = %d %s", code, body)
+	}
+	if strings.Contains(body, "closed") || strings.Contains(body, "err") {
+		t.Fatalf("readyz leaks error details: %s", body)
+	}
+}
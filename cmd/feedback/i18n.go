@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// 界面文案以中文原文作为消息 ID（gettext 的做法）：模板里写 {{t "反馈广场"}}，
+// 中文直接原样输出，其他语言到 web/i18n/<lang>.json 里按原文查译文，查不到就退回中文。
+// 这样模板读起来和以前一样，漏翻的地方也不会变成一串看不懂的 key。
+
+const (
+	langZH = "zh"
+	langEN = "en"
+
+	langCookieName = "lang"
+)
+
+// supportedLangs 的第一个是默认语言。
+var supportedLangs = []string{langZH, langEN}
+
+// catalogs 是各语言的译文表，键是中文原文。中文没有表。
+var catalogs = map[string]map[string]string{}
+
+func loadCatalogs() error {
+	for _, lang := range supportedLangs[1:] {
+		data, err := webFS.ReadFile("web/i18n/" + lang + ".json")
+		if err != nil {
+			return err
+		}
+		m := map[string]string{}
+		if err := json.Unmarshal(data, &m); err != nil {
+			return fmt.Errorf("解析 %s.json 失败: %w", lang, err)
+		}
+		catalogs[lang] = m
+	}
+	return nil
+}
+
+// translate 翻译一条文案，args 不为空时按 fmt 格式化（译文里可以用 %[2]s 调整参数顺序）。
+func translate(lang, msg string, args ...any) string {
+	if v, ok := catalogs[lang][msg]; ok && v != "" {
+		msg = v
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// translateMessage 用于 handler 里拼出来的提示（比如 "导入中断：" + err）：整句查不到时，
+// 按第一个全角冒号拆开，只翻前半句，后面的具体内容原样保留。
+func translateMessage(lang, msg string) string {
+	if lang == langZH || msg == "" {
+		return msg
+	}
+	if v, ok := catalogs[lang][msg]; ok && v != "" {
+		return v
+	}
+	if head, tail, ok := strings.Cut(msg, "："); ok {
+		if v, ok := catalogs[lang][head]; ok && v != "" {
+			return v + ": " + tail
+		}
+	}
+	return msg
+}
+
+func validLang(s string) bool {
+	for _, l := range supportedLangs {
+		if s == l {
+			return true
+		}
+	}
+	return false
+}
+
+// requestLang 决定本次请求用哪种语言：先看用户手动选的（Cookie），再按 Accept-Language 协商。
+func requestLang(r *http.Request) string {
+	if c, err := r.Cookie(langCookieName); err == nil && validLang(c.Value) {
+		return c.Value
+	}
+	return negotiateLang(r.Header.Get("Accept-Language"))
+}
+
+// negotiateLang 按 q 值从高到低找第一个支持的语言，只比较主语言（zh-TW、en-GB 都算）。
+func negotiateLang(header string) string {
+	type tag struct {
+		lang string
+		q    float64
+	}
+	var tags []tag
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		primary, _, _ := strings.Cut(strings.ToLower(name), "-")
+		if primary != "" && q > 0 {
+			tags = append(tags, tag{primary, q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	for _, t := range tags {
+		if validLang(t.lang) {
+			return t.lang
+		}
+	}
+	return supportedLangs[0]
+}
+
+// handleSetLang 记住用户选的语言，然后回到原来的页面。
+func (a *App) handleSetLang(w http.ResponseWriter, r *http.Request) {
+	lang := r.PathValue("lang")
+	if !validLang(lang) {
+		http.NotFound(w, r)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     langCookieName,
+		Value:    lang,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   a.cfg.AppBaseURLHasTLS(),
+		MaxAge:   int(365 * 24 * time.Hour / time.Second),
+	})
+	http.Redirect(w, r, localRedirect(r.URL.Query().Get("next")), http.StatusFound)
+}
+
+// localRedirect 只允许跳回站内路径，防止被拿来做开放跳转。
+// 浏览器会删掉 URL 里的制表符、换行，还把 \ 当成 /，所以 /%09/evil.com、/\evil.com 这类写法
+// 到了浏览器那边都是 //evil.com；含空白、控制字符或反斜杠的一律不认。
+func localRedirect(next string) string {
+	if strings.ContainsFunc(next, func(r rune) bool { return r <= ' ' || r == 0x7f || r == '\\' || unicode.IsSpace(r) }) {
+		return "/"
+	}
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil ||
+		!strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		return "/"
+	}
+	return next
+}
+
+// 日期格式：中文保持原来的数字格式，英文用月份缩写。
+var dateLayouts = map[string]struct{ date, datetime string }{
+	langZH: {"2006-01-02", "2006-01-02 15:04"},
+	langEN: {"Jan 2, 2006", "Jan 2, 2006 15:04"},
+}
+
+func formatDate(lang string, t time.Time) string {
+	return t.Format(dateLayouts[lang].date)
+}
+
+func formatDateTime(lang string, t time.Time) string {
+	return t.Format(dateLayouts[lang].datetime)
+}
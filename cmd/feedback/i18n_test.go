@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLocalRedirect(t *testing.T) {
+	cases := []struct{ next, want string }{
+		{"/square?page=2", "/square?page=2"},
+		{"/u/bob#top", "/u/bob#top"},
+		{"/square/%20x", "/square/%20x"},
+		{"", "/"},
+		{"square", "/"},
+		{"https://evil.com/", "/"},
+		{"//evil.com", "/"},
+		{"/\\evil.com", "/"},
+		{"/\t/evil.com", "/"},
+		{"/\r\n/evil.com", "/"},
+		{"/\n/evil.com", "/"},
+		{"\t//evil.com", "/"},
+		{"/\u00a0/evil.com", "/"},
+		{"/x\\y", "/"},
+		{"/\x00/evil.com", "/"},
+		{"/\u00a0/evil.com", "/"},
+	}
+	for _, c := range cases {
+		if got := localRedirect(c.next); got != c.want {
+			t.Errorf("localRedirect(%q) = %q, want %q", c.next, got, c.want)
+		}
+	}
+}
+
+func TestLangSwitchRedirect(t *testing.T) {
+	a := &App{}
+	r := httptest.NewRequest(http.MethodGet, "/lang/en?next=/%09/evil.com", nil)
+	r.SetPathValue("lang", "en")
+	w := httptest.NewRecorder()
+	a.handleSetLang(w, r)
+	if loc := w.Header().Get("Location"); loc != "/" {
+		t.Fatalf("Location = %q, want /", loc)
+	}
+}
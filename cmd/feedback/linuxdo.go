@@ -6,7 +6,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"database/sql"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
@@ -79,6 +79,9 @@ func (a *App) fetchLinuxDoUser(ctx context.Context, accessToken string) (LinuxDo
 		return LinuxDoUser{}, err
 	}
 	req.Header.Set("Authorization", "Bearer "+accessToken)
+	if id := requestID(ctx); id != "" {
+		req.Header.Set("X-Request-ID", id)
+	}
 
 	res, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -125,7 +128,7 @@ func (a *App) handleLogin(w http.ResponseWriter, r *http.Request) {
 
 	state, err := a.createOAuthState()
 	if err != nil {
-		a.renderError(w, r, http.StatusInternalServerError, "生成 state 失败")
+		a.serverError(w, r, "生成 state 失败", err)
 		return
 	}
 	a.setStateCookie(w, state)
@@ -136,6 +139,7 @@ func (a *App) handleLogin(w http.ResponseWriter, r *http.Request) {
 
 func (a *App) handleLinuxCallback(w http.ResponseWriter, r *http.Request) {
 	if err := a.cfg.validateLinuxDo(); err != nil {
+		appMetrics.oauthCallbacks.inc("not_configured")
 		a.renderError(w, r, http.StatusPreconditionFailed, err.Error())
 		return
 	}
@@ -144,6 +148,7 @@ func (a *App) handleLinuxCallback(w http.ResponseWriter, r *http.Request) {
 	code := strings.TrimSpace(q.Get("code"))
 	state := strings.TrimSpace(q.Get("state"))
 	if code == "" || state == "" {
+		appMetrics.oauthCallbacks.inc("bad_request")
 		a.renderError(w, r, http.StatusBadRequest, "缺少 code/state")
 		return
 	}
@@ -151,6 +156,8 @@ func (a *App) handleLinuxCallback(w http.ResponseWriter, r *http.Request) {
 	saved := a.readStateCookie(r)
 	a.clearStateCookie(w)
 	if saved == "" || saved != state {
+		appMetrics.oauthCallbacks.inc("state_mismatch")
+		slog.WarnContext(r.Context(), "OAuth state 校验失败", "has_cookie", saved != "")
 		a.renderError(w, r, http.StatusBadRequest, "state 校验失败")
 		return
 	}
@@ -160,6 +167,8 @@ func (a *App) handleLinuxCallback(w http.ResponseWriter, r *http.Request) {
 
 	tok, err := a.linuxDoConfig().Exchange(ctx, code)
 	if err != nil {
+		appMetrics.oauthCallbacks.inc("exchange_failed")
+		slog.ErrorContext(ctx, "Linux DO token 交换失败", "err", err)
 		a.renderError(w, r, http.StatusBadGateway, "token 交换失败")
 		return
 	}
@@ -170,50 +179,33 @@ func (a *App) handleLinuxCallback(w http.ResponseWriter, r *http.Request) {
 		access = tok.AccessToken
 	}
 	if access == "" {
+		appMetrics.oauthCallbacks.inc("exchange_failed")
+		slog.ErrorContext(ctx, "Linux DO token 响应缺少 access_token")
 		a.renderError(w, r, http.StatusBadGateway, "token 响应缺少 access_token")
 		return
 	}
 
 	u, err := a.fetchLinuxDoUser(ctx, access)
 	if err != nil {
+		appMetrics.oauthCallbacks.inc("userinfo_failed")
+		slog.ErrorContext(ctx, "获取 Linux DO 用户信息失败", "err", err)
 		a.renderError(w, r, http.StatusBadGateway, err.Error())
 		return
 	}
 
-	userID, err := a.upsertUserByLinuxDoID(ctx, u)
+	userID, err := a.store.UpsertUserByLinuxDoID(ctx, u)
 	if err != nil {
-		a.renderError(w, r, http.StatusInternalServerError, "保存用户失败")
+		appMetrics.oauthCallbacks.inc("db_error")
+		a.serverError(w, r, "保存用户失败", err)
 		return
 	}
 
 	old := a.readSession(r)
 	a.writeSession(w, r, Session{UID: userID, IsAdmin: old.IsAdmin})
+	appMetrics.oauthCallbacks.inc("success")
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
-func (a *App) upsertUserByLinuxDoID(ctx context.Context, u LinuxDoUser) (string, error) {
-	// 先查一下是否存在
-	var id string
-	err := a.db.QueryRowContext(ctx, `SELECT id FROM users WHERE linux_do_id = ?`, u.ID).Scan(&id)
-	if err == nil && id != "" {
-		_, _ = a.db.ExecContext(ctx, `UPDATE users SET username = ?, avatar_url = ? WHERE id = ?`, u.Username, nullIfEmpty(u.AvatarURL), id)
-		return id, nil
-	}
-	if err != nil && err != sql.ErrNoRows {
-		return "", err
-	}
-
-	id = newID()
-	_, err = a.db.ExecContext(ctx,
-		`INSERT INTO users(id, linux_do_id, username, avatar_url, created_at) VALUES(?,?,?,?,?)`,
-		id, u.ID, u.Username, nullIfEmpty(u.AvatarURL), time.Now().Unix(),
-	)
-	if err != nil {
-		return "", err
-	}
-	return id, nil
-}
-
 func nullIfEmpty(s string) any {
 	if strings.TrimSpace(s) == "" {
 		return nil
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// setupLogger 按配置装好全局 slog，标准库 log 的输出也会经过它。
+func setupLogger(cfg Config, out io.Writer) {
+	opts := &slog.HandlerOptions{Level: cfg.LogLevel}
+	var h slog.Handler
+	if cfg.LogFormat == "json" {
+		h = slog.NewJSONHandler(out, opts)
+	} else {
+		h = slog.NewTextHandler(out, opts)
+	}
+	slog.SetDefault(slog.New(requestIDHandler{h}))
+}
+
+// parseLogLevel 接受 debug / info / warn / error。
+func parseLogLevel(s string) (slog.Level, error) {
+	var lv slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := lv.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("LOG_LEVEL 只能是 debug/info/warn/error: %q", s)
+	}
+	return lv, nil
+}
+
+type ctxKey int
+
+const requestMetaKey ctxKey = iota
+
+// requestMeta 是挂在请求 context 上的每请求数据。用指针，里层中间件可以直接往里填，
+// 不用再 r.WithContext 换一个请求对象（那样外层就读不到 mux 写进去的 r.Pattern 了）。
+type requestMeta struct {
+	id    string
+	nonce string // CSP nonce，见 withMiddleware
+}
+
+func metaFrom(ctx context.Context) *requestMeta {
+	m, _ := ctx.Value(requestMetaKey).(*requestMeta)
+	return m
+}
+
+// requestID 取当前请求的 ID，不在请求里时为空。
+func requestID(ctx context.Context) string {
+	if m := metaFrom(ctx); m != nil {
+		return m.id
+	}
+	return ""
+}
+
+// requestIDHandler 给所有带请求 context 的日志（slog.XxxContext）自动加上 request_id，
+// 这样 store 之类拿不到 *http.Request 的地方也能和访问日志对上。
+type requestIDHandler struct{ slog.Handler }
+
+func (h requestIDHandler) Handle(ctx context.Context, rec slog.Record) error {
+	if id := requestID(ctx); id != "" {
+		rec.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, rec)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// validRequestID 只接受反代传来的短 ID，防止有人往日志里塞换行或超长内容。
+func validRequestID(s string) bool {
+	if s == "" || len(s) > 128 {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// accessRecorder 在 statusRecorder 的基础上再记一下写出的字节数。
+type accessRecorder struct {
+	http.ResponseWriter
+	code  int
+	bytes int64
+}
+
+func (s *accessRecorder) WriteHeader(code int) {
+	if s.code == 0 {
+		s.code = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *accessRecorder) Write(b []byte) (int, error) {
+	if s.code == 0 {
+		s.code = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += int64(n)
+	return n, err
+}
+
+// withRequestLog 分配（或沿用反代传来的）X-Request-ID，放进 context 和响应头，
+// 请求结束后打一条访问日志。
+func withRequestLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		r = r.WithContext(context.WithValue(r.Context(), requestMetaKey, &requestMeta{id: id}))
+
+		if isProbePath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &accessRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.code == 0 {
+			rec.code = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		if rec.code >= 500 {
+			level = slog.LevelError
+		}
+		slog.LogAttrs(r.Context(), level, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", r.Pattern),
+			slog.Int("status", rec.code),
+			slog.Int64("bytes", rec.bytes),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("ip", clientIP(r)),
+			slog.String("user_agent", r.UserAgent()),
+		)
+	})
+}
+
+// fatal 打一条错误日志后退出。
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
@@ -4,9 +4,9 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
-	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -16,35 +16,66 @@ import (
 )
 
 func main() {
+	// config check 要自己处理校验失败，不能走 mustLoadConfig。
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		if err := runConfigCommand(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	cfg := mustLoadConfig()
+	setupLogger(cfg, os.Stderr)
+	for _, w := range cfg.warnings() {
+		slog.Warn(w)
+	}
 
-	db, err := openDB(cfg.DatabasePath)
-	if err != nil {
-		log.Fatalf("打开数据库失败: %v", err)
+	if len(os.Args) > 1 {
+		runCommand(cfg, os.Args[1], os.Args[2:])
+		return
 	}
-	defer db.Close()
 
-	if err := ensureSchema(db); err != nil {
-		log.Fatalf("初始化数据库结构失败: %v", err)
+	store, db, err := openStore(cfg)
+	if err != nil {
+		fatal("打开数据库失败", err)
 	}
+	defer db.Close()
 
 	app := &App{
-		cfg: cfg,
-		db:  db,
+		cfg:   cfg,
+		db:    db,
+		store: store,
+
+		mdCache: newHTMLCache(cfg.MarkdownCacheMB << 20),
 	}
 	app.initTemplates()
 
 	mux := http.NewServeMux()
-	mux.Handle("/static/", app.staticHandler())
+	mux.Handle("GET /static/", app.staticHandler())
+	mux.HandleFunc("GET /healthz", app.handleHealthz)
+	mux.HandleFunc("GET /readyz", app.handleReadyz)
+	mux.HandleFunc("POST "+cspReportPath, app.handleCSPReport)
+	mux.HandleFunc("GET /lang/{lang}", app.handleSetLang)
 
 	mux.HandleFunc("GET /", app.handleHome)
 	mux.HandleFunc("GET /square", app.handleSquare)
 	mux.HandleFunc("GET /square/{id}", app.handleSquareDetail)
+	mux.HandleFunc("GET /u/{username}", app.handleUserProfile)
 	mux.HandleFunc("POST /square/{id}/reply", app.handleCreateReply)
+	mux.HandleFunc("POST /square/{id}/watch", app.handleWatch)
+	mux.HandleFunc("POST /square/{id}/report", app.handleReportFeedback)
+	mux.HandleFunc("POST /square/{id}/replies/{rid}/report", app.handleReportReply)
 
 	mux.HandleFunc("GET /new", app.handleNewFeedbackForm)
+	mux.HandleFunc("POST /preview", app.handlePreview)
 	mux.HandleFunc("POST /new", app.handleCreateFeedback)
 	mux.HandleFunc("GET /me", app.handleMyFeedback)
+	mux.HandleFunc("GET /me/settings", app.handleMySettings)
+	mux.HandleFunc("GET /me/export", app.handleMyExport)
+	mux.HandleFunc("POST /me/timezone", app.handleSetTimezone)
+	mux.HandleFunc("POST /me/delete", app.handleMyDelete)
+	mux.HandleFunc("POST /me/delete/cancel", app.handleMyDeleteCancel)
 
 	mux.HandleFunc("GET /login", app.handleLogin)
 	mux.HandleFunc("GET /linux", app.handleLinuxCallback)
@@ -52,27 +83,144 @@ func main() {
 
 	mux.HandleFunc("GET /admin", app.handleAdminPage)
 	mux.HandleFunc("POST /admin", app.handleAdminLogin)
+	mux.HandleFunc("GET /admin/audit", app.handleAdminAudit)
+	mux.HandleFunc("GET /admin/audit/export", app.handleAdminAuditExport)
+	mux.HandleFunc("GET /admin/data", app.handleAdminData)
+	mux.HandleFunc("GET /admin/export", app.handleAdminExport)
+	mux.HandleFunc("POST /admin/import", app.handleAdminImport)
+	mux.HandleFunc("GET /admin/blocklist", app.handleAdminBlocklist)
+	mux.HandleFunc("POST /admin/blocklist", app.handleAdminBlocklistAdd)
+	mux.HandleFunc("POST /admin/blocklist/{id}/delete", app.handleAdminBlocklistDelete)
+	mux.HandleFunc("GET /admin/templates", app.handleAdminTemplates)
+	mux.HandleFunc("POST /admin/templates", app.handleAdminTemplateCreate)
+	mux.HandleFunc("POST /admin/templates/{id}", app.handleAdminTemplateUpdate)
+	mux.HandleFunc("POST /admin/templates/{id}/delete", app.handleAdminTemplateDelete)
+	mux.HandleFunc("GET /admin/queue", app.handleAdminQueue)
+	mux.HandleFunc("POST /admin/queue/{id}/approve", app.handleAdminQueueApprove)
+	mux.HandleFunc("POST /admin/queue/{id}/reject", app.handleAdminQueueReject)
+	mux.HandleFunc("POST /admin/feedback/{id}/merge", app.handleAdminMerge)
+	mux.HandleFunc("POST /admin/feedback/{id}/unmerge", app.handleAdminUnmerge)
+	mux.HandleFunc("GET /admin/reports", app.handleAdminReports)
+	mux.HandleFunc("POST /admin/reports/{type}/{id}/dismiss", app.handleAdminReportDismiss)
+	mux.HandleFunc("POST /admin/reports/{type}/{id}/hide", app.handleAdminReportHide)
+
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	if cfg.BackupDir != "" {
+		if _, ok := store.(*sqliteStore); ok {
+			go runScheduledBackups(bgCtx, db.reader(), cfg)
+		} else {
+			slog.Warn("BACKUP_DIR 只对 SQLite 生效，已忽略")
+		}
+	}
+
+	go app.runAccountDeletions(bgCtx)
+
+	var certs *certReloader
+	if cfg.tlsEnabled() {
+		certs, err = newCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.MetricsClientCAFile)
+		if err != nil {
+			fatal("加载 TLS 证书失败", err)
+		}
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go certs.watch(bgCtx, hup, 30*time.Second)
+	}
+	scheme := "http"
+	if certs != nil {
+		scheme = "https"
+	}
+
+	var metricsServer *http.Server
+	switch {
+	case cfg.MetricsAddr != "":
+		mmux := http.NewServeMux()
+		mmux.HandleFunc("GET /metrics", app.handleMetrics)
+		metricsServer = &http.Server{
+			Addr:              cfg.MetricsAddr,
+			Handler:           mmux,
+			ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		if certs != nil && cfg.MetricsClientCAFile != "" {
+			metricsServer.TLSConfig = certs.mtlsConfig()
+		}
+		go func() {
+			mscheme := "http"
+			if metricsServer.TLSConfig != nil {
+				mscheme = "https"
+			}
+			slog.Info("指标服务启动", "addr", mscheme+"://"+cfg.MetricsAddr+"/metrics", "mtls", metricsServer.TLSConfig != nil)
+			if err := serve(metricsServer); err != nil {
+				fatal("指标服务退出", err)
+			}
+		}()
+	case cfg.MetricsToken != "":
+		mux.HandleFunc("GET /metrics", app.handleMetrics)
+	}
 
 	server := &http.Server{
 		Addr:              cfg.ListenAddr,
-		Handler:           app.withMiddleware(mux),
+		Handler:           withRequestLog(app.withMiddleware(withMetrics(mux))),
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
 		ReadHeaderTimeout: 10 * time.Second,
 	}
+	if certs != nil {
+		server.TLSConfig = certs.serverConfig()
+	}
 
 	go func() {
-		log.Printf("启动: http://%s", cfg.ListenAddr)
-		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			log.Fatalf("服务退出: %v", err)
+		slog.Info("启动", "addr", scheme+"://"+cfg.ListenAddr)
+		if err := serve(server); err != nil {
+			fatal("服务退出", err)
 		}
 	}()
 
+	var redirectServer *http.Server
+	if cfg.HTTPRedirectAddr != "" {
+		redirectServer = newRedirectServer(cfg.HTTPRedirectAddr, cfg.AppBaseURL)
+		go func() {
+			slog.Info("HTTP 跳转服务启动", "addr", "http://"+cfg.HTTPRedirectAddr, "to", cfg.AppBaseURL)
+			if err := serve(redirectServer); err != nil {
+				fatal("HTTP 跳转服务退出", err)
+			}
+		}()
+	}
+
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 	<-stop
+	app.health.draining.Store(true)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	_ = server.Shutdown(ctx)
+	if metricsServer != nil {
+		_ = metricsServer.Shutdown(ctx)
+	}
+	if redirectServer != nil {
+		_ = redirectServer.Shutdown(ctx)
+	}
+}
+
+// runCommand 处理 `feedback <子命令>`，出错时以非零状态退出。
+func runCommand(cfg Config, name string, args []string) {
+	var err error
+	switch name {
+	case "backup":
+		err = runBackupCommand(cfg, args)
+	case "restore":
+		err = runRestoreCommand(cfg, args)
+	case "export":
+		err = runExportCommand(cfg, args)
+	case "import":
+		err = runImportCommand(cfg, args)
+	default:
+		err = fmt.Errorf("未知子命令 %q（可用: backup, restore, export, import, config）", name)
+	}
+	if err != nil {
+		fatal(name+" 失败", err)
+	}
 }
 
 func mustLoadConfig() Config {
@@ -2,24 +2,318 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
+	"errors"
 	"html/template"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/styles"
 	"github.com/microcosm-cc/bluemonday"
 	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/ast"
 	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
 	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
 )
 
+// 代码高亮只输出 class，颜色在 /static/highlight.css 里，这样 CSP 不用放开内联样式。
+const highlightStyle = "github"
+
 var md = goldmark.New(
-	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithExtensions(
+		extension.GFM, // 含任务列表
+		highlighting.NewHighlighting(
+			highlighting.WithStyle(highlightStyle),
+			highlighting.WithFormatOptions(chromahtml.WithClasses(true)),
+		),
+		refExtension{},
+	),
 	goldmark.WithRendererOptions(html.WithUnsafe()), // 先渲染，后统一 sanitize
 )
 
-var sanitize = bluemonday.UGCPolicy()
+var sanitize = newSanitizer()
+
+func newSanitizer() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+
+	// 代码高亮：只放行 chroma 自己的 token class，用户没法借用站点的样式（比如 btn）伪装成界面元素。
+	var classes []string
+	for _, c := range chroma.StandardTypes {
+		if c != "" {
+			classes = append(classes, regexp.QuoteMeta(c))
+		}
+	}
+	sort.Strings(classes)
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^(`+strings.Join(classes, "|")+`)$`)).OnElements("span", "pre")
+
+	// #ID 引用和 @ 提及
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^(ref|mention)$`)).OnElements("a")
+
+	// 任务列表的勾选框。bluemonday 没法要求几个属性同时出现，
+	// 「必须是 disabled 的 checkbox」由 renderMarkdown 在 sanitize 之后再筛一遍。
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").OnElements("input")
+	return p
+}
+
+// inputRe 匹配 sanitize 之后的 <input>，此时属性只剩 type / checked / disabled，写法也统一了。
+var inputRe = regexp.MustCompile(`<input[^>]*>`)
 
+// renderMarkdown 只依赖内容本身：Markdown → HTML → sanitize。
+// #ID / @用户名 此时只是占位链接，显示前还要经过 App.linkRefs 按数据库里的情况补全。
 func renderMarkdown(s string) template.HTML {
 	var buf bytes.Buffer
-	_ = md.Convert([]byte(s), &buf)
-	return template.HTML(sanitize.SanitizeBytes(buf.Bytes()))
+	if err := md.Convert([]byte(s), &buf); err != nil {
+		slog.Error("Markdown 渲染失败", "err", err)
+	}
+	out := sanitize.SanitizeBytes(buf.Bytes())
+	// 原始 HTML 里手写的 <input> 也能过 sanitizer；只留下任务列表那种不能点的勾选框，其余删掉。
+	out = inputRe.ReplaceAllFunc(out, func(m []byte) []byte {
+		if bytes.Contains(m, []byte(`type="checkbox"`)) && bytes.Contains(m, []byte(`disabled=""`)) {
+			return m
+		}
+		return nil
+	})
+	return template.HTML(out)
 }
 
+// markdown 是模板和预览实际用的渲染：renderMarkdown（走缓存）之后再解析引用。
+// 解析引用要查库，ctx 用当前请求的，客户端断开时查询跟着取消。
+func (a *App) markdown(ctx context.Context, s string) template.HTML {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	return a.linkRefs(ctx, a.mdCache.render(s))
+}
+
+// highlightCSS 由 chroma 按样式生成，启动时算一次。
+var highlightCSS = func() []byte {
+	var buf bytes.Buffer
+	if err := chromahtml.New(chromahtml.WithClasses(true)).WriteCSS(&buf, styles.Get(highlightStyle)); err != nil {
+		slog.Error("生成代码高亮样式失败", "err", err)
+	}
+	return buf.Bytes()
+}()
+
+// ---- #ID 引用与 @提及 ----
+
+var kindRef = ast.NewNodeKind("Ref")
+
+// refNode 是 #ID 或 @用户名，渲染成带 class 的站内链接。
+type refNode struct {
+	ast.BaseInline
+	class, href, text string
+	inLink            bool // 在原始 HTML 的 <a> … </a> 里面，见 rawLinkTransformer
+}
+
+func (n *refNode) Kind() ast.NodeKind { return kindRef }
+
+func (n *refNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"href": n.href}, nil)
+}
+
+type refExtension struct{}
+
+func (refExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithInlineParsers(
+		util.Prioritized(feedbackRefParser{}, 500),
+		util.Prioritized(mentionParser{}, 500),
+	))
+	m.Parser().AddOptions(parser.WithASTTransformers(util.Prioritized(rawLinkTransformer{}, 500)))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(refRenderer{}, 500)))
+}
+
+var (
+	rawLinkOpenRe  = regexp.MustCompile(`(?i)<a[\s>]`)
+	rawLinkCloseRe = regexp.MustCompile(`(?i)</a\s*>`)
+)
+
+// rawLinkTransformer 按文档顺序数原始 HTML 里 <a> 的开闭，把落在其中的引用标成 inLink，
+// 免得 <a href="/u/bob">@bob</a> 这种写法渲染出嵌套的 <a>。
+type rawLinkTransformer struct{}
+
+func (rawLinkTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	depth := 0
+	count := func(raw []byte) {
+		depth += len(rawLinkOpenRe.FindAllIndex(raw, -1)) - len(rawLinkCloseRe.FindAllIndex(raw, -1))
+		depth = max(depth, 0)
+	}
+	_ = ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := node.(type) {
+		case *ast.RawHTML:
+			count(n.Segments.Value(source))
+		case *ast.HTMLBlock:
+			count(n.Lines().Value(source))
+			if n.HasClosure() {
+				count(n.ClosureLine.Value(source))
+			}
+		case *refNode:
+			n.inLink = depth > 0
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
+
+// 只在词首触发：a#b、foo@bar.com 这种不算。
+func atWordStart(block text.Reader) bool {
+	r := block.PrecendingCharacter()
+	return !(r < 128 && isWordByte(byte(r))) && r != '&' && r != '/'
+}
+
+// feedbackRefParser 识别 #<32 位十六进制 ID>。
+type feedbackRefParser struct{}
+
+func (feedbackRefParser) Trigger() []byte { return []byte{'#'} }
+
+func (feedbackRefParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	if !atWordStart(block) {
+		return nil
+	}
+	line, _ := block.PeekLine()
+	n := 0
+	for 1+n < len(line) && (line[1+n] >= '0' && line[1+n] <= '9' || line[1+n] >= 'a' && line[1+n] <= 'f') {
+		n++
+	}
+	if n != 32 || (1+n < len(line) && isWordByte(line[1+n])) {
+		return nil
+	}
+	id := string(line[1 : 1+n])
+	block.Advance(1 + n)
+	return &refNode{class: "ref", href: "/square/" + id, text: "#" + id}
+}
+
+// mentionParser 识别 @用户名（字母数字和 _ . -，结尾的 . - 不算，方便写在句末）。
+type mentionParser struct{}
+
+func (mentionParser) Trigger() []byte { return []byte{'@'} }
+
+func (mentionParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	if !atWordStart(block) {
+		return nil
+	}
+	line, _ := block.PeekLine()
+	n := 0
+	for 1+n < len(line) && n < 40 && (isWordByte(line[1+n]) || line[1+n] == '.' || line[1+n] == '-') {
+		n++
+	}
+	for n > 0 && (line[n] == '.' || line[n] == '-') {
+		n--
+	}
+	if n == 0 || (1+n < len(line) && isWordByte(line[1+n])) {
+		return nil
+	}
+	name := string(line[1 : 1+n])
+	block.Advance(1 + n)
+	return &refNode{class: "mention", href: "/u/" + url.PathEscape(name), text: "@" + name}
+}
+
+type refRenderer struct{}
+
+func (refRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindRef, renderRef)
+}
+
+func renderRef(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*refNode)
+	// 已经在链接文字里了就不再套一层 <a>。
+	if n.inLink {
+		_, _ = w.WriteString(template.HTMLEscapeString(n.text))
+		return ast.WalkSkipChildren, nil
+	}
+	for p := n.Parent(); p != nil; p = p.Parent() {
+		if p.Kind() == ast.KindLink || p.Kind() == ast.KindAutoLink {
+			_, _ = w.WriteString(template.HTMLEscapeString(n.text))
+			return ast.WalkSkipChildren, nil
+		}
+	}
+	_, _ = w.WriteString(`<a href="` + template.HTMLEscapeString(n.href) + `" class="` + n.class + `">` + template.HTMLEscapeString(n.text) + `</a>`)
+	return ast.WalkSkipChildren, nil
+}
+
+// 经过 sanitizer 之后的占位链接（bluemonday 会补上 rel="nofollow"）。
+var (
+	feedbackRefRe = regexp.MustCompile(`<a href="/square/([0-9a-f]{32})" class="ref"(?: rel="nofollow")?>#[0-9a-f]{32}</a>`)
+	mentionRe     = regexp.MustCompile(`<a href="/u/([A-Za-z0-9_.%-]{1,120})" class="mention"(?: rel="nofollow")?>@[A-Za-z0-9_.-]{1,40}</a>`)
+)
+
+// maxRefsPerDoc 限制一篇内容里最多解析多少个不同的引用，每个都要查一次库。
+const maxRefsPerDoc = 50
+
+// linkRefs 按数据库补全 #ID 和 @用户名：
+//   - 反馈不存在 → 退回纯文本；匿名访客也能看到的 → 链接后面带上标题；
+//     其他（私有、待审、被隐藏，或者查库出错）→ 只留短 ID 的链接，不露标题。
+//   - 用户不存在或已注销 → 退回纯文本。
+//
+// 判断是否公开用的是匿名访客的视角，结果和谁在看无关，私有反馈的标题不会出现在任何人看到的正文里。
+func (a *App) linkRefs(ctx context.Context, h template.HTML) template.HTML {
+	s := string(h)
+	if !strings.Contains(s, `class="ref"`) && !strings.Contains(s, `class="mention"`) {
+		return h
+	}
+
+	feedback := map[string]string{}
+	s = feedbackRefRe.ReplaceAllStringFunc(s, func(m string) string {
+		id := feedbackRefRe.FindStringSubmatch(m)[1]
+		if out, ok := feedback[id]; ok {
+			return out
+		}
+		if len(feedback) >= maxRefsPerDoc {
+			return m
+		}
+		out := `<a href="/square/` + id + `" class="ref">#` + id[:8] + `</a>`
+		item, err := a.store.FeedbackByID(ctx, id)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			out = "#" + id
+		case err != nil:
+			slog.WarnContext(ctx, "解析反馈引用失败", "feedback", id, "err", err)
+		case item.canView(Session{}):
+			out = `<a href="/square/` + id + `" class="ref" title="` + template.HTMLEscapeString(item.Title) + `">#` + id[:8] + ` ` + template.HTMLEscapeString(item.Title) + `</a>`
+		}
+		feedback[id] = out
+		return out
+	})
+
+	users := map[string]string{}
+	s = mentionRe.ReplaceAllStringFunc(s, func(m string) string {
+		name, err := url.PathUnescape(mentionRe.FindStringSubmatch(m)[1])
+		if err != nil {
+			return m
+		}
+		if out, ok := users[name]; ok {
+			return out
+		}
+		if len(users) >= maxRefsPerDoc {
+			return m
+		}
+		out := "@" + template.HTMLEscapeString(name)
+		if u, err := a.store.UserByUsername(ctx, name); err == nil && !u.Deleted() {
+			out = `<a href="/u/` + url.PathEscape(name) + `" class="mention">@` + template.HTMLEscapeString(name) + `</a>`
+		}
+		users[name] = out
+		return out
+	})
+	return template.HTML(s)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"html/template"
+	"sync"
+)
+
+// markdownVersion 参与缓存键。改了 goldmark 扩展、代码高亮或 sanitizer 规则，都要把它加一，
+// 旧版本渲染出来的 HTML 就不会再被命中，留在缓存里的旧条目会被 LRU 慢慢挤掉。
+const markdownVersion = 2
+
+// htmlCache 缓存 renderMarkdown 的结果，键是 (markdownVersion, 内容) 的 SHA-256。
+// 内容一改哈希就变了，编辑过的反馈 / 回复自然用不到旧结果，不需要额外的失效逻辑。
+// 只缓存和数据库无关的第一步，#ID / @用户名 每次显示时仍然按当前状态解析。
+//
+// 按 HTML 字节数限制大小（近似值，不算 map 和链表本身的开销），超出时淘汰最久没用的。
+type htmlCache struct {
+	mu       sync.Mutex
+	maxBytes int
+	size     int
+	ll       *list.List // 前面是最近用过的
+	items    map[[sha256.Size]byte]*list.Element
+}
+
+type htmlCacheEntry struct {
+	key  [sha256.Size]byte
+	html template.HTML
+}
+
+// newHTMLCache 返回一个最多占 maxBytes 的缓存；maxBytes <= 0 时返回 nil，表示不缓存。
+func newHTMLCache(maxBytes int) *htmlCache {
+	if maxBytes <= 0 {
+		return nil
+	}
+	return &htmlCache{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    map[[sha256.Size]byte]*list.Element{},
+	}
+}
+
+func markdownKey(s string) [sha256.Size]byte {
+	h := sha256.New()
+	var v [8]byte
+	binary.BigEndian.PutUint64(v[:], markdownVersion)
+	h.Write(v[:])
+	h.Write([]byte(s))
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+	return key
+}
+
+// render 先查缓存，没有就渲染并放进去。c 为 nil 时直接渲染。
+func (c *htmlCache) render(s string) template.HTML {
+	if c == nil {
+		return renderMarkdown(s)
+	}
+	key := markdownKey(s)
+	if h, ok := c.get(key); ok {
+		appMetrics.markdownCache.inc("hit")
+		return h
+	}
+	appMetrics.markdownCache.inc("miss")
+	h := renderMarkdown(s)
+	c.add(key, h)
+	return h
+}
+
+func (c *htmlCache) get(key [sha256.Size]byte) (template.HTML, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return "", false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*htmlCacheEntry).html, true
+}
+
+func (c *htmlCache) add(key [sha256.Size]byte, h template.HTML) {
+	// 单条超过上限四分之一的不缓存，免得一条大内容把其他条目全挤掉。
+	if len(h) > c.maxBytes/4 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&htmlCacheEntry{key: key, html: h})
+	c.size += len(h)
+	for c.size > c.maxBytes {
+		e := c.ll.Back()
+		ent := e.Value.(*htmlCacheEntry)
+		c.ll.Remove(e)
+		delete(c.items, ent.key)
+		c.size -= len(ent.html)
+	}
+}
+
+// stats 返回当前条目数和占用的字节数，给 /metrics 用。
+func (c *htmlCache) stats() (entries, bytes int) {
+	if c == nil {
+		return 0, 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len(), c.size
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdownRawLinks(t *testing.T) {
+	cases := []struct {
+		name, in string
+		anchors  int  // 输出里 <a 的个数
+		mention  bool // @bob 是否变成了提及链接
+	}{
+		{"plain", "hi @bob", 1, true},
+		{"markdown link", "[@bob](/x)", 1, false},
+		{"raw link", `<a href="/x">@bob</a>`, 1, false},
+		{"raw link upper", `<A HREF="/x">see @bob</A>`, 1, false},
+		{"after raw link", `<a href="/x">x</a> @bob`, 2, true},
+		{"html block", "<a href=\"/x\">\n\n@bob\n\n</a>", 1, false},
+	}
+	for _, c := range cases {
+		out := string(renderMarkdown(c.in))
+		if n := strings.Count(strings.ToLower(out), "<a "); n != c.anchors {
+			t.Errorf("%s: %d anchors in %s", c.name, n, out)
+		}
+		if got := strings.Contains(out, `class="mention"`); got != c.mention {
+			t.Errorf("%s: mention = %v in %s", c.name, got, out)
+		}
+	}
+}
+
+func TestRenderMarkdownInputs(t *testing.T) {
+	cases := []struct {
+		name, in string
+		keep     bool
+	}{
+		{"task done", "- [x] 完成", true},
+		{"task open", "- [ ] 待办", true},
+		{"raw disabled", `<input disabled>`, false},
+		{"raw checkbox", `<input type="checkbox" checked>`, false},
+		{"raw text", `<input type="text" disabled>`, false},
+	}
+	for _, c := range cases {
+		out := string(renderMarkdown(c.in))
+		if got := strings.Contains(out, "<input"); got != c.keep {
+			t.Errorf("%s: input kept = %v in %s", c.name, got, out)
+		}
+	}
+}
+
+func TestMarkdownUsesRequestContext(t *testing.T) {
+	s := openTestSQLite(t)
+	addUser(t, s, "bob")
+	a := &App{store: s}
+
+	if out := string(a.markdown(context.Background(), "hi @bob")); !strings.Contains(out, `href="/u/bob" class="mention"`) {
+		t.Fatalf("mention not linked: %s", out)
+	}
+	// 请求已经取消时不再查库，引用退回纯文本。
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if out := string(a.markdown(ctx, "hi @bob")); strings.Contains(out, "<a") {
+		t.Fatalf("canceled request still resolved mention: %s", out)
+	}
+}
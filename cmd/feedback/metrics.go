@@ -0,0 +1,263 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 这里自己实现了 Prometheus 文本格式里用得到的那一小部分（counter、histogram），
+// 不为了几个指标引入 client_golang 一整套依赖。
+
+type metricSeries struct {
+	labels []string
+	value  float64  // counter
+	counts []uint64 // histogram：每个桶的累计计数
+	sum    float64  // histogram
+	count  uint64   // histogram
+}
+
+type metricVec struct {
+	name    string
+	help    string
+	kind    string // counter / histogram
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+func newCounter(name, help string, labels ...string) *metricVec {
+	m := &metricVec{name: name, help: help, kind: "counter", labels: labels, series: map[string]*metricSeries{}}
+	if len(labels) == 0 {
+		m.get(nil) // 没有标签的计数器从 0 开始就要出现
+	}
+	return m
+}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *metricVec {
+	return &metricVec{name: name, help: help, kind: "histogram", labels: labels, buckets: buckets, series: map[string]*metricSeries{}}
+}
+
+func (m *metricVec) get(values []string) *metricSeries {
+	key := strings.Join(values, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &metricSeries{labels: append([]string(nil), values...)}
+		if m.kind == "histogram" {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+func (m *metricVec) inc(values ...string) {
+	m.mu.Lock()
+	m.get(values).value++
+	m.mu.Unlock()
+}
+
+func (m *metricVec) observe(v float64, values ...string) {
+	m.mu.Lock()
+	s := m.get(values)
+	for i, b := range m.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+	m.mu.Unlock()
+}
+
+func (m *metricVec) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+	keys := make([]string, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := m.series[k]
+		lbl := formatLabels(m.labels, s.labels)
+		if m.kind == "counter" {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, wrapLabels(lbl), formatFloat(s.value))
+			continue
+		}
+		for i, b := range m.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, wrapLabels(joinLabels(lbl, `le="`+formatFloat(b)+`"`)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, wrapLabels(joinLabels(lbl, `le="+Inf"`)), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, wrapLabels(lbl), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, wrapLabels(lbl), s.count)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) string {
+	parts := make([]string, len(names))
+	for i, n := range names {
+		parts[i] = n + `="` + labelEscaper.Replace(values[i]) + `"`
+	}
+	return strings.Join(parts, ",")
+}
+
+func joinLabels(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "," + b
+}
+
+func wrapLabels(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "{" + s + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	dbBuckets      = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+)
+
+// appMetrics 是全局的指标表。数据库包装层和各个 handler 直接往里记。
+var appMetrics = struct {
+	httpRequests    *metricVec
+	httpDuration    *metricVec
+	dbDuration      *metricVec
+	oauthCallbacks  *metricVec
+	feedbackCreated *metricVec
+	repliesCreated  *metricVec
+	markdownCache   *metricVec
+}{
+	httpRequests:    newCounter("feedback_http_requests_total", "HTTP requests by route pattern, method and status code.", "route", "method", "code"),
+	httpDuration:    newHistogram("feedback_http_request_duration_seconds", "HTTP request latency by route pattern.", latencyBuckets, "route", "method"),
+	dbDuration:      newHistogram("feedback_db_query_duration_seconds", "Database call latency by call type and statement verb.", dbBuckets, "call", "verb"),
+	oauthCallbacks:  newCounter("feedback_oauth_callbacks_total", "Linux DO OAuth callback outcomes.", "outcome"),
+	feedbackCreated: newCounter("feedback_feedback_created_total", "Feedback created, by initial moderation state.", "moderation"),
+	repliesCreated:  newCounter("feedback_replies_created_total", "Replies created."),
+	markdownCache:   newCounter("feedback_markdown_cache_lookups_total", "Rendered Markdown cache lookups.", "result"),
+}
+
+// observeDB 记录一次数据库调用的耗时。verb 取 SQL 的第一个关键字，避免把整条语句当标签。
+func observeDB(call, query string, start time.Time) {
+	verb := strings.TrimSpace(query)
+	if i := strings.IndexAny(verb, " \t\n("); i > 0 {
+		verb = verb[:i]
+	}
+	verb = strings.ToLower(verb)
+	switch verb {
+	case "select", "insert", "update", "delete", "with", "pragma", "vacuum":
+	default:
+		verb = "other"
+	}
+	appMetrics.dbDuration.observe(time.Since(start).Seconds(), call, verb)
+}
+
+func writeRuntimeMetrics(w io.Writer) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	gauge := func(name, help string, v float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, formatFloat(v))
+	}
+	fmt.Fprintf(w, "# HELP go_info Go version.\n# TYPE go_info gauge\ngo_info{version=%q} 1\n", runtime.Version())
+	gauge("go_goroutines", "Number of goroutines.", float64(runtime.NumGoroutine()))
+	gauge("go_memstats_heap_alloc_bytes", "Heap bytes allocated and in use.", float64(ms.HeapAlloc))
+	gauge("go_memstats_heap_inuse_bytes", "Heap bytes in in-use spans.", float64(ms.HeapInuse))
+	gauge("go_memstats_sys_bytes", "Bytes obtained from the OS.", float64(ms.Sys))
+	gauge("go_memstats_heap_objects", "Number of allocated heap objects.", float64(ms.HeapObjects))
+	fmt.Fprintf(w, "# HELP go_memstats_alloc_bytes_total Total bytes allocated.\n# TYPE go_memstats_alloc_bytes_total counter\ngo_memstats_alloc_bytes_total %d\n", ms.TotalAlloc)
+	fmt.Fprintf(w, "# HELP go_gc_cycles_total Completed GC cycles.\n# TYPE go_gc_cycles_total counter\ngo_gc_cycles_total %d\n", ms.NumGC)
+	fmt.Fprintf(w, "# HELP go_gc_pause_seconds_total Total GC stop-the-world pause time.\n# TYPE go_gc_pause_seconds_total counter\ngo_gc_pause_seconds_total %s\n", formatFloat(float64(ms.PauseTotalNs)/1e9))
+	gauge("process_start_time_seconds", "Start time of the process since unix epoch.", float64(processStart.Unix()))
+}
+
+var processStart = time.Now()
+
+// handleMetrics 输出 Prometheus 文本格式。配置了 METRICS_TOKEN 时要求 Authorization: Bearer 头；
+// 不认 URL 参数，免得 token 落进访问日志和代理日志。
+func (a *App) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if t := a.cfg.MetricsToken; t != "" {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(t)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, m := range []*metricVec{
+		appMetrics.httpRequests,
+		appMetrics.httpDuration,
+		appMetrics.dbDuration,
+		appMetrics.oauthCallbacks,
+		appMetrics.feedbackCreated,
+		appMetrics.repliesCreated,
+		appMetrics.markdownCache,
+	} {
+		m.write(w)
+	}
+	entries, size := a.mdCache.stats()
+	fmt.Fprintf(w, "# HELP feedback_markdown_cache_entries Rendered Markdown cache entries.\n# TYPE feedback_markdown_cache_entries gauge\nfeedback_markdown_cache_entries %d\n", entries)
+	fmt.Fprintf(w, "# HELP feedback_markdown_cache_bytes Approximate bytes of cached rendered HTML.\n# TYPE feedback_markdown_cache_bytes gauge\nfeedback_markdown_cache_bytes %d\n", size)
+	writeRuntimeMetrics(w)
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.code == 0 {
+		s.code = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.code == 0 {
+		s.code = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// withMetrics 要直接包在 mux 外面：mux 会把匹配到的路由写进 r.Pattern，
+// 中间如果有人 r.WithContext 换了请求对象，这里就拿不到了。
+// 没匹配上的请求统一记成 unmatched，免得扫描器把标签撑爆。
+func withMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		if rec.code == 0 {
+			rec.code = http.StatusOK
+		}
+		appMetrics.httpRequests.inc(route, r.Method, strconv.Itoa(rec.code))
+		appMetrics.httpDuration.observe(time.Since(start).Seconds(), route, r.Method)
+	})
+}
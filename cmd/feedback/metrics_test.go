@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetricsToken(t *testing.T) {
+	a := &App{cfg: Config{MetricsToken: "secret"}}
+	cases := []struct {
+		name, url, auth string
+		want            int
+	}{
+		{"bearer", "/metrics", "Bearer secret", http.StatusOK},
+		{"wrong", "/metrics", "Bearer nope", http.StatusUnauthorized},
+		{"no scheme", "/metrics", "secret", http.StatusUnauthorized},
+		{"query", "/metrics?token=secret", "", http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, c.url, nil)
+		if c.auth != "" {
+			r.Header.Set("Authorization", c.auth)
+		}
+		w := httptest.NewRecorder()
+		a.handleMetrics(w, r)
+		if w.Code != c.want {
+			t.Errorf("%s: status = %d, want %d", c.name, w.Code, c.want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// feedbacks.moderation 的取值。只有 published 的公开反馈会出现在广场。
+const (
+	moderationPublished = "published"
+	moderationPending   = "pending" // REQUIRE_APPROVAL 下等待审核
+	moderationFlagged   = "flagged" // 被反垃圾规则拦下
+	moderationRejected  = "rejected"
+)
+
+func (f Feedback) IsPublished() bool {
+	return f.Moderation == moderationPublished
+}
+
+// canView 判断当前会话能不能看到这条反馈：未发布、被隐藏或私有的只有作者和管理员能看。
+func (f Feedback) canView(sess Session) bool {
+	if sess.IsAdmin || (sess.UID != "" && sess.UID == f.UserID) {
+		return true
+	}
+	return f.IsPublic && f.IsPublished() && !f.Hidden
+}
+
+func (a *App) handleAdminQueue(w http.ResponseWriter, r *http.Request) {
+	sess := a.readSession(r)
+	if !sess.IsAdmin {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	user := a.sessionUser(ctx, sess)
+
+	list, err := a.store.ListFeedback(ctx, FeedbackQuery{
+		Moderation: []string{moderationPending, moderationFlagged},
+		Oldest:     true,
+		Limit:      200,
+	})
+	if err != nil {
+		a.serverError(w, r, "查询失败", err)
+		return
+	}
+
+	flash := ""
+	if r.URL.Query().Get("bad") == "1" {
+		flash = "拒绝时需要填写原因（500 字以内），作者会看到它。"
+	}
+
+	a.render(w, r, "admin_queue.html", ViewData{
+		Title:      "审核队列",
+		Session:    sess,
+		User:       user,
+		IsAuthed:   sess.UID != "",
+		Feedback:   list,
+		FlashError: flash,
+	})
+}
+
+func (a *App) handleAdminQueueApprove(w http.ResponseWriter, r *http.Request) {
+	a.moderate(w, r, moderationPublished)
+}
+
+func (a *App) handleAdminQueueReject(w http.ResponseWriter, r *http.Request) {
+	a.moderate(w, r, moderationRejected)
+}
+
+// moderate 把一条反馈切到 state。拒绝必须带原因，原因会展示给作者。
+func (a *App) moderate(w http.ResponseWriter, r *http.Request, state string) {
+	sess := a.readSession(r)
+	if !sess.IsAdmin {
+		http.NotFound(w, r)
+		return
+	}
+	id := strings.TrimSpace(r.PathValue("id"))
+
+	if err := r.ParseForm(); err != nil {
+		a.renderError(w, r, http.StatusBadRequest, "表单解析失败")
+		return
+	}
+	reason := ""
+	if state == moderationRejected {
+		reason = strings.TrimSpace(r.FormValue("reason"))
+		if reason == "" || len(reason) > 500 {
+			http.Redirect(w, r, "/admin/queue?bad=1", http.StatusFound)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	item, err := a.store.FeedbackByID(ctx, id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := a.setModeration(ctx, r, sess, item, state, reason); err != nil {
+		a.serverError(w, r, "写入失败", err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/queue", http.StatusFound)
+}
+
+// setModeration 改审核状态并记审计、通知关注者。审核队列和详情页回复时顺带改状态都走这里。
+func (a *App) setModeration(ctx context.Context, r *http.Request, sess Session, item *Feedback, state, reason string) error {
+	action := auditFeedbackApprove
+	note := ""
+	if state != moderationPublished {
+		action = auditFeedbackReject
+		note = item.ModerationNote
+	}
+	if err := a.store.SetFeedbackModeration(ctx, item.ID, state, note, reason); err != nil {
+		return err
+	}
+	a.audit(ctx, r, sess, action, "feedback", item.ID,
+		map[string]any{"moderation": item.Moderation, "moderation_note": item.ModerationNote, "reject_reason": item.RejectReason},
+		map[string]any{"moderation": state, "moderation_note": note, "reject_reason": reason},
+	)
+	a.notifyWatchers(ctx, item.ID, sess.UID, action)
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"io"
+	"net/http"
+)
+
+// maxContentLen 和写反馈、写回复时的长度上限一致，超过的内容反正也提交不了，没必要预览。
+const maxContentLen = 20000
+
+// handlePreview 把 Markdown 渲染成 HTML 片段给编辑器的「预览」标签页用，
+// 走的是和正式显示完全相同的渲染（包括 sanitizer），所见即所得。
+// 只给登录用户用，免得被当成公开的渲染服务。
+func (a *App) handlePreview(w http.ResponseWriter, r *http.Request) {
+	lang := requestLang(r)
+	if a.readSession(r).UID == "" {
+		http.Error(w, translate(lang, "请先登录"), http.StatusUnauthorized)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 4*maxContentLen)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, translate(lang, "表单解析失败"), http.StatusBadRequest)
+		return
+	}
+	content := r.FormValue("content")
+	if len(content) > maxContentLen {
+		http.Error(w, translate(lang, "内容太长"), http.StatusRequestEntityTooLarge)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = io.WriteString(w, string(a.markdown(r.Context(), content)))
+}
+
+// previewMarkdown 是没有脚本时「预览」按钮走的服务端渲染。
+func (a *App) previewMarkdown(ctx context.Context, content string) template.HTML {
+	if len(content) > maxContentLen {
+		return ""
+	}
+	return a.markdown(ctx, content)
+}
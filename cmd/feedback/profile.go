@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// profilePageSize 是用户主页每页显示的反馈条数。
+const profilePageSize = 20
+
+// AuthorDeleted 表示作者已注销，列表里不再链接到主页。
+func (f Feedback) AuthorDeleted() bool {
+	return f.Username == deletedUsername
+}
+
+// Initial 是没有头像时占位显示的首字母。
+func (u User) Initial() string {
+	for _, c := range u.Username {
+		return strings.ToUpper(string(c))
+	}
+	return "?"
+}
+
+// handleUserProfile 是 /u/{username}：头像、注册时间和公开反馈。
+// 管理员还能看到这个用户的私有 / 待审核 / 被隐藏的反馈，以及针对这些反馈的审核记录。
+func (a *App) handleUserProfile(w http.ResponseWriter, r *http.Request) {
+	sess := a.readSession(r)
+	name := strings.TrimSpace(r.PathValue("username"))
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	profile, err := a.store.UserByUsername(ctx, name)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && profile.Deleted()) {
+		a.renderError(w, r, http.StatusNotFound, "用户不存在")
+		return
+	}
+	if err != nil {
+		a.serverError(w, r, "查询失败", err)
+		return
+	}
+	user := a.sessionUser(ctx, sess)
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	page = max(page, 1)
+
+	q := FeedbackQuery{UserID: profile.ID, Square: !sess.IsAdmin, Limit: profilePageSize + 1, Offset: (page - 1) * profilePageSize}
+	list, err := a.store.ListFeedback(ctx, q)
+	if err != nil {
+		a.serverError(w, r, "查询失败", err)
+		return
+	}
+	hasNext := len(list) > profilePageSize
+	if hasNext {
+		list = list[:profilePageSize]
+	}
+
+	d := ViewData{
+		Title:    profile.Username,
+		Session:  sess,
+		User:     user,
+		IsAuthed: sess.UID != "",
+		Profile:  profile,
+		Feedback: list,
+		PageNum:  page,
+	}
+	if page > 1 {
+		d.PrevPage = page - 1
+	}
+	if hasNext {
+		d.NextPage = page + 1
+	}
+
+	d.PublicCount, err = a.store.CountFeedback(ctx, FeedbackQuery{UserID: profile.ID, Square: true})
+	if err != nil {
+		a.serverError(w, r, "查询失败", err)
+		return
+	}
+	if sess.IsAdmin {
+		if d.AllCount, err = a.store.CountFeedback(ctx, FeedbackQuery{UserID: profile.ID}); err != nil {
+			a.serverError(w, r, "查询失败", err)
+			return
+		}
+		if d.Audit, err = a.queryAudit(ctx, AuditFilter{Author: profile.ID}, 50); err != nil {
+			a.serverError(w, r, "查询失败", err)
+			return
+		}
+	}
+
+	a.render(w, r, "profile.html", d)
+}
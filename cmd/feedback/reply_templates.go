@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 回复模板（常用回复）：管理员在 /admin/templates 维护，在详情页的回复框里一键插入。
+// 正文里可以写占位符，插入时换成这条反馈的信息；模板还可以带一个默认的审核状态，插入时一起选上。
+
+// ReplyTemplate 是一条回复模板。Status 为空表示不改状态，否则是 moderationPublished / moderationRejected。
+type ReplyTemplate struct {
+	ID        string
+	Title     string
+	Content   string
+	Status    string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// replyStatuses 是回复时可以顺带切换的审核状态（空串表示不改）。
+var replyStatuses = []string{"", moderationPublished, moderationRejected}
+
+func validReplyStatus(s string) bool {
+	for _, v := range replyStatuses {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// expandReplyTemplate 替换占位符：{{author}} 作者用户名，{{title}} 反馈标题，{{link}} 反馈的完整链接。
+// 不认识的占位符原样保留。
+func (a *App) expandReplyTemplate(content string, item *Feedback) string {
+	return strings.NewReplacer(
+		"{{author}}", item.Username,
+		"{{title}}", item.Title,
+		"{{link}}", a.cfg.AppBaseURL+"/square/"+item.ID,
+	).Replace(content)
+}
+
+func (a *App) replyTemplates(ctx context.Context) ([]ReplyTemplate, error) {
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT id, title, content, status, created_at, updated_at FROM reply_templates ORDER BY title, created_at
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []ReplyTemplate
+	for rows.Next() {
+		var t ReplyTemplate
+		var created, updated int64
+		if err := rows.Scan(&t.ID, &t.Title, &t.Content, &t.Status, &created, &updated); err != nil {
+			return nil, err
+		}
+		t.CreatedAt = time.Unix(created, 0)
+		t.UpdatedAt = time.Unix(updated, 0)
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
+
+func (a *App) replyTemplateByID(ctx context.Context, id string) (*ReplyTemplate, error) {
+	var t ReplyTemplate
+	var created, updated int64
+	err := a.db.QueryRowContext(ctx, `
+		SELECT id, title, content, status, created_at, updated_at FROM reply_templates WHERE id = ?
+	`, id).Scan(&t.ID, &t.Title, &t.Content, &t.Status, &created, &updated)
+	if err != nil {
+		return nil, err
+	}
+	t.CreatedAt = time.Unix(created, 0)
+	t.UpdatedAt = time.Unix(updated, 0)
+	return &t, nil
+}
+
+func (a *App) handleAdminTemplates(w http.ResponseWriter, r *http.Request) {
+	sess := a.readSession(r)
+	if !sess.IsAdmin {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	user := a.sessionUser(ctx, sess)
+
+	list, err := a.replyTemplates(ctx)
+	if err != nil {
+		a.serverError(w, r, "查询失败", err)
+		return
+	}
+
+	flash := ""
+	if r.URL.Query().Get("bad") == "1" {
+		flash = "模板名称（100 字以内）和内容都不能为空。"
+	}
+
+	a.render(w, r, "admin_templates.html", ViewData{
+		Title:          "回复模板",
+		Session:        sess,
+		User:           user,
+		IsAuthed:       sess.UID != "",
+		ReplyTemplates: list,
+		FlashError:     flash,
+	})
+}
+
+// replyTemplateForm 读出新建 / 编辑表单，不合法时返回 false。
+func replyTemplateForm(r *http.Request) (ReplyTemplate, bool) {
+	if err := r.ParseForm(); err != nil {
+		return ReplyTemplate{}, false
+	}
+	t := ReplyTemplate{
+		Title:   strings.TrimSpace(r.FormValue("title")),
+		Content: strings.TrimSpace(r.FormValue("content")),
+		Status:  r.FormValue("status"),
+	}
+	ok := t.Title != "" && len([]rune(t.Title)) <= 100 && t.Content != "" && len(t.Content) <= maxContentLen && validReplyStatus(t.Status)
+	return t, ok
+}
+
+func (a *App) handleAdminTemplateCreate(w http.ResponseWriter, r *http.Request) {
+	sess := a.readSession(r)
+	if !sess.IsAdmin {
+		http.NotFound(w, r)
+		return
+	}
+	t, ok := replyTemplateForm(r)
+	if !ok {
+		http.Redirect(w, r, "/admin/templates?bad=1", http.StatusFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	id := newID()
+	now := time.Now().Unix()
+	_, err := a.db.ExecContext(ctx, `
+		INSERT INTO reply_templates(id, title, content, status, created_at, updated_at) VALUES(?,?,?,?,?,?)
+	`, id, t.Title, t.Content, t.Status, now, now)
+	if err != nil {
+		a.serverError(w, r, "写入失败", err)
+		return
+	}
+	a.audit(ctx, r, sess, auditTemplateCreate, "reply_template", id, nil, map[string]any{
+		"title": t.Title, "content": t.Content, "status": t.Status,
+	})
+
+	http.Redirect(w, r, "/admin/templates", http.StatusFound)
+}
+
+func (a *App) handleAdminTemplateUpdate(w http.ResponseWriter, r *http.Request) {
+	sess := a.readSession(r)
+	if !sess.IsAdmin {
+		http.NotFound(w, r)
+		return
+	}
+	id := strings.TrimSpace(r.PathValue("id"))
+	t, ok := replyTemplateForm(r)
+	if !ok {
+		http.Redirect(w, r, "/admin/templates?bad=1", http.StatusFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	old, err := a.replyTemplateByID(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		a.serverError(w, r, "查询失败", err)
+		return
+	}
+	_, err = a.db.ExecContext(ctx, `
+		UPDATE reply_templates SET title = ?, content = ?, status = ?, updated_at = ? WHERE id = ?
+	`, t.Title, t.Content, t.Status, time.Now().Unix(), id)
+	if err != nil {
+		a.serverError(w, r, "写入失败", err)
+		return
+	}
+	a.audit(ctx, r, sess, auditTemplateUpdate, "reply_template", id,
+		map[string]any{"title": old.Title, "content": old.Content, "status": old.Status},
+		map[string]any{"title": t.Title, "content": t.Content, "status": t.Status},
+	)
+
+	http.Redirect(w, r, "/admin/templates", http.StatusFound)
+}
+
+func (a *App) handleAdminTemplateDelete(w http.ResponseWriter, r *http.Request) {
+	sess := a.readSession(r)
+	if !sess.IsAdmin {
+		http.NotFound(w, r)
+		return
+	}
+	id := strings.TrimSpace(r.PathValue("id"))
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	old, err := a.replyTemplateByID(ctx, id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if _, err := a.db.ExecContext(ctx, `DELETE FROM reply_templates WHERE id = ?`, id); err != nil {
+		a.serverError(w, r, "删除失败", err)
+		return
+	}
+	a.audit(ctx, r, sess, auditTemplateDelete, "reply_template", id,
+		map[string]any{"title": old.Title, "content": old.Content, "status": old.Status}, nil)
+
+	http.Redirect(w, r, "/admin/templates", http.StatusFound)
+}
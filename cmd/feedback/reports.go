@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+type ReportReason struct {
+	Code  string
+	Label string
+}
+
+// 举报原因分类，顺序即表单里的顺序。
+var reportReasons = []ReportReason{
+	{"spam", "垃圾广告"},
+	{"abuse", "辱骂 / 人身攻击"},
+	{"off_topic", "跑题 / 灌水"},
+	{"privacy", "泄露他人隐私"},
+	{"other", "其他"},
+}
+
+func validReportReason(code string) bool {
+	for _, r := range reportReasons {
+		if r.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	reportTargetFeedback = "feedback"
+	reportTargetReply    = "reply"
+)
+
+type ReasonCount struct {
+	Label string
+	Count int
+}
+
+// ReportItem 是按被举报对象聚合后的一行，给管理员分拣用。
+type ReportItem struct {
+	TargetType string
+	TargetID   string
+	FeedbackID string
+	Title      string // 所属反馈的标题
+	Excerpt    string // 被举报的是回复时，回复开头一段
+	Hidden     bool
+	Reporters  int
+	Reasons    []ReasonCount
+	Details    []string
+	LastAt     time.Time
+}
+
+// UserReport 是某个用户自己提交的一条举报，个人数据导出用。
+type UserReport struct {
+	TargetType string `json:"target_type"`
+	TargetID   string `json:"target_id"`
+	FeedbackID string `json:"feedback_id"`
+	Reason     string `json:"reason"`
+	Detail     string `json:"detail,omitempty"`
+	CreatedAt  int64  `json:"created_at"`
+	ResolvedAt int64  `json:"resolved_at,omitempty"`
+}
+
+// reportsByUser 列出某个用户提交过的全部举报（含已处理的），按时间正序。
+func (a *App) reportsByUser(ctx context.Context, uid string) ([]UserReport, error) {
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT target_type, target_id, feedback_id, reason, detail, created_at, COALESCE(resolved_at, 0)
+		FROM reports
+		WHERE reporter_user_id = ?
+		ORDER BY created_at, id
+	`, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []UserReport{}
+	for rows.Next() {
+		var it UserReport
+		if err := rows.Scan(&it.TargetType, &it.TargetID, &it.FeedbackID, &it.Reason, &it.Detail, &it.CreatedAt, &it.ResolvedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, it)
+	}
+	return list, rows.Err()
+}
+
+func (a *App) handleReportFeedback(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(r.PathValue("id"))
+	a.submitReport(w, r, reportTargetFeedback, id, id)
+}
+
+func (a *App) handleReportReply(w http.ResponseWriter, r *http.Request) {
+	a.submitReport(w, r, reportTargetReply, strings.TrimSpace(r.PathValue("rid")), strings.TrimSpace(r.PathValue("id")))
+}
+
+func (a *App) submitReport(w http.ResponseWriter, r *http.Request, targetType, targetID, feedbackID string) {
+	sess := a.readSession(r)
+	if sess.UID == "" {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	back := "/square/" + feedbackID
+	if err := r.ParseForm(); err != nil {
+		http.Redirect(w, r, back+"?report=bad", http.StatusFound)
+		return
+	}
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	detail := strings.TrimSpace(r.FormValue("detail"))
+	if !validReportReason(reason) || len(detail) > 500 {
+		http.Redirect(w, r, back+"?report=bad", http.StatusFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if a.accountGone(ctx, sess.UID) {
+		a.clearSession(w)
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	item, err := a.store.FeedbackByID(ctx, feedbackID)
+	if err != nil || !item.canView(sess) {
+		http.NotFound(w, r)
+		return
+	}
+	if targetType == reportTargetFeedback && item.UserID == sess.UID {
+		http.Redirect(w, r, back+"?report=self", http.StatusFound)
+		return
+	}
+	if targetType == reportTargetReply {
+		rep, err := a.store.ReplyByID(ctx, targetID)
+		if err != nil || rep.FeedbackID != feedbackID {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	// 同一个人对同一条内容只算一次；管理员处理过之后可以再举报
+	_, err = a.db.ExecContext(ctx, `
+		INSERT INTO reports(id, target_type, target_id, feedback_id, reporter_user_id, reason, detail, created_at)
+		VALUES(?,?,?,?,?,?,?,?)
+		ON CONFLICT(target_type, target_id, reporter_user_id) WHERE resolved_at IS NULL DO NOTHING
+	`, newID(), targetType, targetID, feedbackID, sess.UID, reason, detail, time.Now().Unix())
+	if err != nil {
+		a.serverError(w, r, "写入失败", err)
+		return
+	}
+
+	if t := a.cfg.ReportHideThreshold; t > 0 {
+		var n int
+		err := a.db.QueryRowContext(ctx, `
+			SELECT COUNT(DISTINCT reporter_user_id) FROM reports
+			WHERE target_type = ? AND target_id = ? AND resolved_at IS NULL
+		`, targetType, targetID).Scan(&n)
+		if err != nil {
+			slog.ErrorContext(ctx, "统计举报人数失败", "target", targetType+"/"+targetID, "err", err)
+		} else if n >= t {
+			// 自动隐藏是系统按阈值做的，不记在触发它的举报人头上。
+			if changed, err := a.setHidden(ctx, targetType, targetID, true); err != nil {
+				slog.ErrorContext(ctx, "自动隐藏失败", "target", targetType+"/"+targetID, "err", err)
+			} else if changed {
+				a.audit(ctx, nil, Session{}, auditReportAutoHide, targetType, targetID,
+					map[string]any{"hidden": false}, map[string]any{"hidden": true, "reporters": n, "threshold": t})
+			}
+		}
+	}
+
+	http.Redirect(w, r, back+"?report=ok", http.StatusFound)
+}
+
+// setHidden 按举报对象类型隐藏 / 取消隐藏，返回状态是否真的变了。
+func (a *App) setHidden(ctx context.Context, targetType, id string, hidden bool) (bool, error) {
+	if targetType == reportTargetReply {
+		return a.store.SetReplyHidden(ctx, id, hidden)
+	}
+	return a.store.SetFeedbackHidden(ctx, id, hidden)
+}
+
+// detailFlash 把详情页 URL 上的一次性提示参数翻译成文案。
+func detailFlash(q url.Values) string {
+	if q.Get("merge_error") == "1" {
+		return "合并失败：目标反馈不存在，或者会形成循环。"
+	}
+	return reportFlash(q.Get("report"))
+}
+
+func reportFlash(v string) string {
+	switch v {
+	case "ok":
+		return "举报已收到，管理员会尽快处理。"
+	case "self":
+		return "不能举报自己的反馈。"
+	case "bad":
+		return "举报提交失败：请选择原因，补充说明不超过 500 字。"
+	}
+	return ""
+}
+
+func (a *App) openReports(ctx context.Context) ([]ReportItem, error) {
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT target_type, target_id, feedback_id, reason, detail, created_at
+		FROM reports
+		WHERE resolved_at IS NULL
+		ORDER BY created_at DESC
+		LIMIT 2000
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byKey := map[string]*ReportItem{}
+	reasonCounts := map[string]map[string]int{}
+	var list []*ReportItem
+	for rows.Next() {
+		var targetType, targetID, feedbackID, reason, detail string
+		var created int64
+		if err := rows.Scan(&targetType, &targetID, &feedbackID, &reason, &detail, &created); err != nil {
+			slog.ErrorContext(ctx, "读取举报行失败", "err", err)
+			continue
+		}
+		key := targetType + "/" + targetID
+		it, ok := byKey[key]
+		if !ok {
+			it = &ReportItem{TargetType: targetType, TargetID: targetID, FeedbackID: feedbackID, LastAt: time.Unix(created, 0)}
+			byKey[key] = it
+			reasonCounts[key] = map[string]int{}
+			list = append(list, it)
+		}
+		it.Reporters++
+		reasonCounts[key][reason]++
+		if detail != "" && len(it.Details) < 5 {
+			it.Details = append(it.Details, detail)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	out := make([]ReportItem, 0, len(list))
+	for _, it := range list {
+		for _, rr := range reportReasons {
+			if n := reasonCounts[it.TargetType+"/"+it.TargetID][rr.Code]; n > 0 {
+				it.Reasons = append(it.Reasons, ReasonCount{Label: rr.Label, Count: n})
+			}
+		}
+		if f, err := a.store.FeedbackByID(ctx, it.FeedbackID); err == nil {
+			it.Title = f.Title
+			if it.TargetType == reportTargetFeedback {
+				it.Hidden = f.Hidden
+			}
+		}
+		if it.TargetType == reportTargetReply {
+			if rep, err := a.store.ReplyByID(ctx, it.TargetID); err == nil {
+				it.Excerpt = truncateRunes(plainText(rep.Content), 120)
+				it.Hidden = rep.Hidden
+			}
+		}
+		out = append(out, *it)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Reporters > out[j].Reporters })
+	return out, nil
+}
+
+func truncateRunes(s string, n int) string {
+	rs := []rune(strings.TrimSpace(s))
+	if len(rs) <= n {
+		return string(rs)
+	}
+	return string(rs[:n]) + "…"
+}
+
+func (a *App) handleAdminReports(w http.ResponseWriter, r *http.Request) {
+	sess := a.readSession(r)
+	if !sess.IsAdmin {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	user := a.sessionUser(ctx, sess)
+
+	list, err := a.openReports(ctx)
+	if err != nil {
+		a.serverError(w, r, "查询失败", err)
+		return
+	}
+
+	a.render(w, r, "admin_reports.html", ViewData{
+		Title:    "举报处理",
+		Session:  sess,
+		User:     user,
+		IsAuthed: sess.UID != "",
+		Reports:  list,
+	})
+}
+
+func (a *App) handleAdminReportDismiss(w http.ResponseWriter, r *http.Request) {
+	a.resolveReports(w, r, false, auditReportDismiss)
+}
+
+func (a *App) handleAdminReportHide(w http.ResponseWriter, r *http.Request) {
+	a.resolveReports(w, r, true, auditReportHide)
+}
+
+// resolveReports 结案某个对象上所有未处理的举报，并把它设成隐藏 / 取消隐藏。
+func (a *App) resolveReports(w http.ResponseWriter, r *http.Request, hide bool, action string) {
+	sess := a.readSession(r)
+	if !sess.IsAdmin {
+		http.NotFound(w, r)
+		return
+	}
+	targetType := r.PathValue("type")
+	targetID := strings.TrimSpace(r.PathValue("id"))
+	if targetType != reportTargetFeedback && targetType != reportTargetReply {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var wasHidden bool
+	if targetType == reportTargetReply {
+		rep, err := a.store.ReplyByID(ctx, targetID)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		wasHidden = rep.Hidden
+	} else {
+		f, err := a.store.FeedbackByID(ctx, targetID)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		wasHidden = f.Hidden
+	}
+
+	var open int
+	if err := a.db.QueryRowContext(ctx,
+		`SELECT COUNT(1) FROM reports WHERE target_type = ? AND target_id = ? AND resolved_at IS NULL`,
+		targetType, targetID,
+	).Scan(&open); err != nil {
+		slog.ErrorContext(ctx, "统计未处理举报失败", "target", targetType+"/"+targetID, "err", err)
+	}
+
+	if _, err := a.setHidden(ctx, targetType, targetID, hide); err != nil {
+		a.serverError(w, r, "写入失败", err)
+		return
+	}
+	_, err := a.db.ExecContext(ctx,
+		`UPDATE reports SET resolved_at = ? WHERE target_type = ? AND target_id = ? AND resolved_at IS NULL`,
+		time.Now().Unix(), targetType, targetID,
+	)
+	if err != nil {
+		a.serverError(w, r, "写入失败", err)
+		return
+	}
+	a.audit(ctx, r, sess, action, targetType, targetID,
+		map[string]any{"hidden": wasHidden, "open_reports": open},
+		map[string]any{"hidden": hide, "open_reports": 0},
+	)
+
+	http.Redirect(w, r, "/admin/reports", http.StatusFound)
+}
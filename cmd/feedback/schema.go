@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// 表结构只在这里写一份，SQLite 和 PostgreSQL 的建表流程都从这几张清单展开：
+// 先建表，再补列（老库升级），最后建依赖补列的索引。
+// 语句里的 {ts} 是 Unix 秒时间戳的列类型，SQLite 用 INTEGER，PostgreSQL 用 BIGINT；
+// 布尔两边都存 0/1，所以查询和扫描代码可以共用。
+
+// schemaTables 是建表和建索引语句。
+var schemaTables = []string{
+	`CREATE TABLE IF NOT EXISTS users (
+		id TEXT PRIMARY KEY,
+		linux_do_id TEXT NOT NULL UNIQUE,
+		username TEXT NOT NULL,
+		avatar_url TEXT,
+		created_at {ts} NOT NULL
+	);`,
+	`CREATE TABLE IF NOT EXISTS feedbacks (
+		id TEXT PRIMARY KEY,
+		title TEXT NOT NULL,
+		content TEXT NOT NULL,
+		is_public INTEGER NOT NULL DEFAULT 1,
+		created_at {ts} NOT NULL,
+		updated_at {ts} NOT NULL,
+		user_id TEXT NOT NULL
+	);`,
+	`CREATE INDEX IF NOT EXISTS idx_feedbacks_public_created ON feedbacks(is_public, created_at);`,
+	`CREATE INDEX IF NOT EXISTS idx_feedbacks_user_created ON feedbacks(user_id, created_at);`,
+	`CREATE TABLE IF NOT EXISTS replies (
+		id TEXT PRIMARY KEY,
+		content TEXT NOT NULL,
+		created_at {ts} NOT NULL,
+		feedback_id TEXT NOT NULL,
+		admin_user_id TEXT
+	);`,
+	`CREATE INDEX IF NOT EXISTS idx_replies_feedback_created ON replies(feedback_id, created_at);`,
+	`CREATE TABLE IF NOT EXISTS audit_logs (
+		id TEXT PRIMARY KEY,
+		created_at {ts} NOT NULL,
+		actor_user_id TEXT,
+		actor_ip TEXT NOT NULL DEFAULT '',
+		action TEXT NOT NULL,
+		target_type TEXT NOT NULL DEFAULT '',
+		target_id TEXT NOT NULL DEFAULT '',
+		before_json TEXT,
+		after_json TEXT
+	);`,
+	`CREATE INDEX IF NOT EXISTS idx_audit_logs_created ON audit_logs(created_at);`,
+	`CREATE INDEX IF NOT EXISTS idx_audit_logs_target ON audit_logs(target_type, target_id, created_at);`,
+	reportsTable,
+	`CREATE INDEX IF NOT EXISTS idx_reports_open ON reports(resolved_at, target_type, target_id);`,
+	`CREATE TABLE IF NOT EXISTS blocked_terms (
+		id TEXT PRIMARY KEY,
+		pattern TEXT NOT NULL,
+		is_regex INTEGER NOT NULL DEFAULT 0,
+		created_at {ts} NOT NULL
+	);`,
+	`CREATE TABLE IF NOT EXISTS watches (
+		feedback_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		created_at {ts} NOT NULL,
+		unread INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY(feedback_id, user_id)
+	);`,
+	`CREATE INDEX IF NOT EXISTS idx_watches_user ON watches(user_id, created_at);`,
+	`CREATE TABLE IF NOT EXISTS reply_templates (
+		id TEXT PRIMARY KEY,
+		title TEXT NOT NULL,
+		content TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT '',
+		created_at {ts} NOT NULL,
+		updated_at {ts} NOT NULL
+	);`,
+	schemaVersionTable,
+}
+
+// reportsTable 单独拿出来，是因为老库升级时要按它重建这张表（见 rebuildSQLiteReports）。
+// 「同一个人对同一条内容只算一次」只约束未处理的举报，由 schemaLate 里的部分唯一索引保证；
+// 管理员处理过之后，同一个人可以再次举报。
+const reportsTable = `CREATE TABLE IF NOT EXISTS reports (
+	id TEXT PRIMARY KEY,
+	target_type TEXT NOT NULL,
+	target_id TEXT NOT NULL,
+	feedback_id TEXT NOT NULL,
+	reporter_user_id TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	detail TEXT NOT NULL DEFAULT '',
+	created_at {ts} NOT NULL,
+	resolved_at {ts}
+);`
+
+// schemaColumns 是建表之后才加的列，老库启动时补上。新表的 CREATE 语句里不写它们，免得两处不一致。
+var schemaColumns = []struct{ table, name, decl string }{
+	{"feedbacks", "moderation", `TEXT NOT NULL DEFAULT 'published'`},
+	{"feedbacks", "moderation_note", `TEXT NOT NULL DEFAULT ''`},
+	{"feedbacks", "reject_reason", `TEXT NOT NULL DEFAULT ''`},
+	{"feedbacks", "hidden", `INTEGER NOT NULL DEFAULT 0`},
+	{"feedbacks", "merged_into", `TEXT NOT NULL DEFAULT ''`},
+	{"feedbacks", "excerpt", `TEXT NOT NULL DEFAULT ''`},
+	{"replies", "hidden", `INTEGER NOT NULL DEFAULT 0`},
+	{"users", "deletion_requested_at", `{ts} NOT NULL DEFAULT 0`},
+	{"users", "deleted_at", `{ts} NOT NULL DEFAULT 0`},
+}
+
+// schemaLate 是依赖补列或老表迁移的索引，放在最后建。
+var schemaLate = []string{
+	`CREATE INDEX IF NOT EXISTS idx_feedbacks_moderation_created ON feedbacks(moderation, created_at);`,
+	`CREATE INDEX IF NOT EXISTS idx_users_username ON users(username);`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_reports_open_reporter ON reports(target_type, target_id, reporter_user_id)
+		WHERE resolved_at IS NULL;`,
+}
+
+// schemaBackfills 是一次性的数据补全：只在库里记录的版本低于 version 时跑一次，
+// 跑完随 schemaVersion 一起记下，之后启动不会再跑（用户之后的改动不会被覆盖）。
+// 语句要能重复执行：版本号要等 afterOpen 里的 dataBackfills 也跑完才写，中途失败下次启动会重来。
+var schemaBackfills = []struct {
+	version int
+	stmt    string
+}{
+	// 自动关注上线之前的反馈，给仍在的作者补上关注；之后作者取消关注不会被加回来。
+	{7, `INSERT INTO watches(feedback_id, user_id, created_at)
+		SELECT f.id, f.user_id, f.created_at FROM feedbacks f JOIN users u ON u.id = f.user_id
+		WHERE u.deleted_at = 0
+		ON CONFLICT(feedback_id, user_id) DO NOTHING;`},
+}
+
+// dataBackfills 和 schemaBackfills 一样是一次性的，只是值要在 Go 里算（比如从 Markdown 提取摘要），
+// 由 afterOpen 在建表之后执行，全部成功后才写入 schemaVersion。
+var dataBackfills = []struct {
+	version int
+	name    string
+	run     func(s *sqlStore, ctx context.Context) error
+}{
+	// 加 excerpt 列（版本 3）之前写入的反馈没有摘要。
+	{3, "补全反馈摘要", (*sqlStore).backfillExcerpts},
+}
+
+// appendOnlyAudit 在库层面拦掉 audit_logs 的 UPDATE / DELETE，两种库的触发器写法不同。
+func (d dialect) appendOnlyAudit() []string {
+	if d == dialectPostgres {
+		return []string{
+			`CREATE OR REPLACE FUNCTION audit_logs_append_only() RETURNS trigger AS $$
+			BEGIN RAISE EXCEPTION 'audit_logs is append-only'; END;
+			$$ LANGUAGE plpgsql;`,
+			`DROP TRIGGER IF EXISTS audit_logs_no_change ON audit_logs;`,
+			`CREATE TRIGGER audit_logs_no_change BEFORE UPDATE OR DELETE ON audit_logs
+			FOR EACH ROW EXECUTE FUNCTION audit_logs_append_only();`,
+		}
+	}
+	return []string{
+		`CREATE TRIGGER IF NOT EXISTS audit_logs_no_update BEFORE UPDATE ON audit_logs
+		BEGIN SELECT RAISE(ABORT, 'audit_logs is append-only'); END;`,
+		`CREATE TRIGGER IF NOT EXISTS audit_logs_no_delete BEFORE DELETE ON audit_logs
+		BEGIN SELECT RAISE(ABORT, 'audit_logs is append-only'); END;`,
+	}
+}
+
+// ddl 把清单里的 {ts} 换成这种库的时间戳类型。
+func (d dialect) ddl(s string) string {
+	ts := "INTEGER"
+	if d == dialectPostgres {
+		ts = "BIGINT"
+	}
+	return strings.ReplaceAll(s, "{ts}", ts)
+}
+
+// schemaStatements 是建表阶段要执行的全部语句（不含补列）。
+func (d dialect) schemaStatements() []string {
+	var out []string
+	for _, s := range schemaTables {
+		out = append(out, d.ddl(s))
+	}
+	return append(out, d.appendOnlyAudit()...)
+}
+
+// lateStatements 是补列之后执行的语句：索引，以及 stored 版本之后的一次性补全。
+// 表结构版本不在这里写，见 afterOpen。
+func (d dialect) lateStatements(stored int) []string {
+	var out []string
+	for _, s := range schemaLate {
+		out = append(out, d.ddl(s))
+	}
+	for _, b := range schemaBackfills {
+		if stored < b.version {
+			out = append(out, b.stmt)
+		}
+	}
+	return out
+}
+
+// readSchemaVersion 读库里记录的表结构版本，没有记录时为 0。q 可以是连接、事务或 *DB。
+func readSchemaVersion(ctx context.Context, q interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}) (int, error) {
+	var v int
+	err := q.QueryRowContext(ctx, `SELECT version FROM schema_version WHERE id = 1`).Scan(&v)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return v, err
+}
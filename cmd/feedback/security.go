@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+const cspReportPath = "/csp-report"
+
+// newNonce 生成一个 CSP nonce：每个请求一个，模板里的 <script> / <style> 带上它才会执行。
+func newNonce() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return base64.StdEncoding.EncodeToString(b[:])
+}
+
+// cspNonce 取当前请求的 CSP nonce。
+func cspNonce(ctx context.Context) string {
+	if m := metaFrom(ctx); m != nil {
+		return m.nonce
+	}
+	return ""
+}
+
+// contentSecurityPolicy 拼出 CSP。脚本只认 nonce；图片允许 https 外链，
+// 因为用户的 Markdown 里可以引用图片，头像也来自 Linux DO。
+func (a *App) contentSecurityPolicy(nonce string) string {
+	directives := []string{
+		"default-src 'none'",
+		"script-src 'nonce-" + nonce + "' 'strict-dynamic'",
+		"style-src 'self' 'nonce-" + nonce + "'",
+		"img-src 'self' https: data:",
+		"font-src 'self'",
+		"connect-src 'self'",
+		"form-action 'self'",
+		"base-uri 'none'",
+		"object-src 'none'",
+		"frame-ancestors " + a.cfg.FrameAncestors,
+		"report-uri " + cspReportPath,
+		"report-to csp",
+	}
+	return strings.Join(directives, "; ")
+}
+
+// permissionsPolicy 把这个站用不到的浏览器能力全部关掉。
+const permissionsPolicy = "accelerometer=(), autoplay=(), camera=(), display-capture=(), geolocation=(), " +
+	"gyroscope=(), magnetometer=(), microphone=(), midi=(), payment=(), usb=(), browsing-topics=()"
+
+// setSecurityHeaders 写入 CSP 以及其他安全相关的响应头。
+func (a *App) setSecurityHeaders(w http.ResponseWriter, nonce string) {
+	h := w.Header()
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Referrer-Policy", "same-origin")
+	h.Set("Permissions-Policy", permissionsPolicy)
+	h.Set("Cross-Origin-Opener-Policy", "same-origin")
+	h.Set("Cross-Origin-Resource-Policy", "same-origin")
+
+	// 老浏览器不认 frame-ancestors，只在完全禁止嵌入时再补一个 X-Frame-Options。
+	if a.cfg.FrameAncestors == "'none'" {
+		h.Set("X-Frame-Options", "DENY")
+	}
+
+	h.Set("Reporting-Endpoints", `csp="`+cspReportPath+`"`)
+	name := "Content-Security-Policy"
+	if a.cfg.CSPReportOnly {
+		name = "Content-Security-Policy-Report-Only"
+	}
+	h.Set(name, a.contentSecurityPolicy(nonce))
+}
+
+// cspViolation 只取日志里有用的几个字段。老格式（report-uri，application/csp-report）
+// 用连字符命名，新格式（Reporting API，application/reports+json）用驼峰，两种都认。
+type cspViolation struct {
+	DocumentURI        string `json:"document-uri"`
+	DocumentURL        string `json:"documentURL"`
+	ViolatedDirective  string `json:"violated-directive"`
+	EffectiveDirective string `json:"effectiveDirective"`
+	BlockedURI         string `json:"blocked-uri"`
+	BlockedURL         string `json:"blockedURL"`
+	SourceFile         string `json:"source-file"`
+	SourceFileNew      string `json:"sourceFile"`
+	LineNumber         int    `json:"line-number"`
+	LineNumberNew      int    `json:"lineNumber"`
+	Disposition        string `json:"disposition"`
+}
+
+// handleCSPReport 接收浏览器上报的 CSP 违规，打成 warn 日志。不管内容对不对都回 204，
+// 浏览器不会处理这里的错误。
+func (a *App) handleCSPReport(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+	if err != nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var list []cspViolation
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/reports+json") {
+		var reports []struct {
+			Type string       `json:"type"`
+			Body cspViolation `json:"body"`
+		}
+		if err := json.Unmarshal(body, &reports); err == nil {
+			for _, rep := range reports {
+				if rep.Type == "csp-violation" {
+					list = append(list, rep.Body)
+				}
+			}
+		}
+	} else {
+		var rep struct {
+			Report cspViolation `json:"csp-report"`
+		}
+		if err := json.Unmarshal(body, &rep); err == nil {
+			list = append(list, rep.Report)
+		}
+	}
+	if len(list) == 0 {
+		slog.DebugContext(r.Context(), "无法解析的 CSP 报告", "content_type", r.Header.Get("Content-Type"), "size", len(body))
+	}
+
+	first := func(x, y string) string {
+		if x != "" {
+			return x
+		}
+		return y
+	}
+	for _, v := range list {
+		line := v.LineNumber
+		if line == 0 {
+			line = v.LineNumberNew
+		}
+		slog.WarnContext(r.Context(), "CSP 违规",
+			"document", first(v.DocumentURI, v.DocumentURL),
+			"directive", first(v.EffectiveDirective, v.ViolatedDirective),
+			"blocked", first(v.BlockedURI, v.BlockedURL),
+			"source", first(v.SourceFile, v.SourceFileNew),
+			"line", line,
+			"disposition", v.Disposition,
+			"ip", clientIP(r),
+		)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// contentDraft 是写库前的一条反馈，交给反垃圾流水线检查。
+type contentDraft struct {
+	UserID   string
+	Title    string
+	Content  string
+	IsPublic bool
+}
+
+type checkAction int
+
+const (
+	checkPass   checkAction = iota
+	checkFlag               // 先不发布，进审核队列
+	checkReject             // 直接拒绝提交
+)
+
+type checkResult struct {
+	Action checkAction
+	Reason string
+	Status int // 仅 checkReject 使用
+}
+
+// contentCheck 是流水线里的一道检查。加规则只需要写一个函数挂到 contentChecks 上。
+type contentCheck func(ctx context.Context, a *App, d contentDraft) (checkResult, error)
+
+var contentChecks = []contentCheck{
+	checkNewAccountThrottle,
+	checkDuplicateContent,
+	checkBlockedTerms,
+	checkLinkCount,
+}
+
+// runContentChecks 按顺序跑完所有检查：遇到拒绝立刻返回，标记原因则累积起来一起进队列。
+func (a *App) runContentChecks(ctx context.Context, d contentDraft) (checkResult, error) {
+	var reasons []string
+	for _, c := range contentChecks {
+		res, err := c(ctx, a, d)
+		if err != nil {
+			return checkResult{}, err
+		}
+		switch res.Action {
+		case checkReject:
+			return res, nil
+		case checkFlag:
+			reasons = append(reasons, res.Reason)
+		}
+	}
+	if len(reasons) > 0 {
+		return checkResult{Action: checkFlag, Reason: strings.Join(reasons, "；")}, nil
+	}
+	return checkResult{Action: checkPass}, nil
+}
+
+func checkNewAccountThrottle(ctx context.Context, a *App, d contentDraft) (checkResult, error) {
+	hours, limit := a.cfg.NewAccountHours, a.cfg.NewAccountMaxPerHour
+	if hours == 0 || limit == 0 {
+		return checkResult{}, nil
+	}
+	u, err := a.store.UserByID(ctx, d.UserID)
+	if err != nil {
+		return checkResult{}, err
+	}
+	if time.Since(u.CreatedAt) >= time.Duration(hours)*time.Hour {
+		return checkResult{}, nil
+	}
+
+	n, err := a.store.CountFeedback(ctx, FeedbackQuery{UserID: d.UserID, Since: time.Now().Add(-time.Hour)})
+	if err != nil {
+		return checkResult{}, err
+	}
+	if n >= int64(limit) {
+		return checkResult{
+			Action: checkReject,
+			Reason: "新注册账号发反馈太频繁了，请过一会儿再试。",
+			Status: http.StatusTooManyRequests,
+		}, nil
+	}
+	return checkResult{}, nil
+}
+
+// checkDuplicateContent 拦住同一用户 30 天内重复提交的相同内容（忽略大小写和空白差异）。
+func checkDuplicateContent(ctx context.Context, a *App, d contentDraft) (checkResult, error) {
+	recent, err := a.store.ListFeedback(ctx, FeedbackQuery{
+		UserID: d.UserID,
+		Since:  time.Now().AddDate(0, 0, -30),
+		Limit:  500,
+	})
+	if err != nil {
+		return checkResult{}, err
+	}
+
+	want := normalizeForDup(d.Content)
+	for _, f := range recent {
+		if normalizeForDup(f.Content) == want {
+			return checkResult{
+				Action: checkReject,
+				Reason: "你最近已经提交过内容相同的反馈了。",
+				Status: http.StatusConflict,
+			}, nil
+		}
+	}
+	return checkResult{}, nil
+}
+
+func normalizeForDup(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
+
+func checkBlockedTerms(ctx context.Context, a *App, d contentDraft) (checkResult, error) {
+	terms, err := a.blockedTerms(ctx)
+	if err != nil {
+		return checkResult{}, err
+	}
+	text := d.Title + "\n" + d.Content
+	lower := strings.ToLower(text)
+	for _, t := range terms {
+		hit := false
+		if t.IsRegex {
+			re, err := regexp.Compile(t.Pattern)
+			if err != nil {
+				slog.WarnContext(ctx, "屏蔽词正则无效，已跳过", "term", t.ID, "pattern", t.Pattern, "err", err)
+				continue
+			}
+			hit = re.MatchString(text)
+		} else {
+			hit = strings.Contains(lower, strings.ToLower(t.Pattern))
+		}
+		if hit {
+			return checkResult{Action: checkFlag, Reason: "命中屏蔽词：" + t.Pattern}, nil
+		}
+	}
+	return checkResult{}, nil
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\bhttps?://|\bwww\.`)
+
+func checkLinkCount(ctx context.Context, a *App, d contentDraft) (checkResult, error) {
+	if a.cfg.SpamMaxLinks == 0 {
+		return checkResult{}, nil
+	}
+	n := len(linkPattern.FindAllStringIndex(d.Title+"\n"+d.Content, -1))
+	if n > a.cfg.SpamMaxLinks {
+		return checkResult{Action: checkFlag, Reason: "链接过多"}, nil
+	}
+	return checkResult{}, nil
+}
+
+type BlockedTerm struct {
+	ID        string
+	Pattern   string
+	IsRegex   bool
+	CreatedAt time.Time
+}
+
+func (a *App) blockedTerms(ctx context.Context) ([]BlockedTerm, error) {
+	rows, err := a.db.QueryContext(ctx, `SELECT id, pattern, is_regex, created_at FROM blocked_terms ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []BlockedTerm
+	for rows.Next() {
+		var t BlockedTerm
+		var isRegex, created int64
+		if err := rows.Scan(&t.ID, &t.Pattern, &isRegex, &created); err != nil {
+			slog.ErrorContext(ctx, "读取屏蔽词失败", "err", err)
+			continue
+		}
+		t.IsRegex = isRegex == 1
+		t.CreatedAt = time.Unix(created, 0)
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
+
+func (a *App) handleAdminBlocklist(w http.ResponseWriter, r *http.Request) {
+	sess := a.readSession(r)
+	if !sess.IsAdmin {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	user := a.sessionUser(ctx, sess)
+
+	terms, err := a.blockedTerms(ctx)
+	if err != nil {
+		a.serverError(w, r, "查询失败", err)
+		return
+	}
+
+	flash := ""
+	if r.URL.Query().Get("bad") == "1" {
+		flash = "屏蔽词不能为空，正则需要能编译通过。"
+	}
+
+	a.render(w, r, "admin_blocklist.html", ViewData{
+		Title:        "屏蔽词",
+		Session:      sess,
+		User:         user,
+		IsAuthed:     sess.UID != "",
+		BlockedTerms: terms,
+		FlashError:   flash,
+	})
+}
+
+func (a *App) handleAdminBlocklistAdd(w http.ResponseWriter, r *http.Request) {
+	sess := a.readSession(r)
+	if !sess.IsAdmin {
+		http.NotFound(w, r)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		a.renderError(w, r, http.StatusBadRequest, "表单解析失败")
+		return
+	}
+
+	pattern := strings.TrimSpace(r.FormValue("pattern"))
+	isRegex := r.FormValue("is_regex") == "1"
+	if pattern == "" || len(pattern) > 500 {
+		http.Redirect(w, r, "/admin/blocklist?bad=1", http.StatusFound)
+		return
+	}
+	if isRegex {
+		if _, err := regexp.Compile(pattern); err != nil {
+			http.Redirect(w, r, "/admin/blocklist?bad=1", http.StatusFound)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	id := newID()
+	_, err := a.db.ExecContext(ctx,
+		`INSERT INTO blocked_terms(id, pattern, is_regex, created_at) VALUES(?,?,?,?)`,
+		id, pattern, boolToInt(isRegex), time.Now().Unix(),
+	)
+	if err != nil {
+		a.serverError(w, r, "写入失败", err)
+		return
+	}
+	a.audit(ctx, r, sess, auditBlocklistAdd, "blocked_term", id, nil, map[string]any{
+		"pattern":  pattern,
+		"is_regex": isRegex,
+	})
+
+	http.Redirect(w, r, "/admin/blocklist", http.StatusFound)
+}
+
+func (a *App) handleAdminBlocklistDelete(w http.ResponseWriter, r *http.Request) {
+	sess := a.readSession(r)
+	if !sess.IsAdmin {
+		http.NotFound(w, r)
+		return
+	}
+	id := strings.TrimSpace(r.PathValue("id"))
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var t BlockedTerm
+	var isRegex int64
+	err := a.db.QueryRowContext(ctx, `SELECT id, pattern, is_regex FROM blocked_terms WHERE id = ?`, id).
+		Scan(&t.ID, &t.Pattern, &isRegex)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if _, err := a.db.ExecContext(ctx, `DELETE FROM blocked_terms WHERE id = ?`, id); err != nil {
+		a.serverError(w, r, "删除失败", err)
+		return
+	}
+	a.audit(ctx, r, sess, auditBlocklistDelete, "blocked_term", id, map[string]any{
+		"pattern":  t.Pattern,
+		"is_regex": isRegex == 1,
+	}, nil)
+
+	http.Redirect(w, r, "/admin/blocklist", http.StatusFound)
+}
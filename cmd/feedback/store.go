@@ -0,0 +1,554 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Store 是用户、反馈、回复三类核心数据的读写接口。
+// 审计、举报、屏蔽词这些辅助表量小、只有管理员在用，仍然直接走 *DB。
+type Store interface {
+	UserByID(ctx context.Context, id string) (*User, error)
+	UserByLinuxDoID(ctx context.Context, linuxDoID string) (*User, error)
+	UserByUsername(ctx context.Context, username string) (*User, error)
+	ListUsers(ctx context.Context) ([]User, error)
+	CreateUser(ctx context.Context, u *User) error
+	UpsertUserByLinuxDoID(ctx context.Context, u LinuxDoUser) (string, error)
+	SetUserDeletionRequest(ctx context.Context, id string, at time.Time) error
+	UsersDueForDeletion(ctx context.Context, requestedBefore time.Time) ([]User, error)
+	DeleteUserData(ctx context.Context, id string, deleteFeedback bool) error
+
+	FeedbackByID(ctx context.Context, id string) (*Feedback, error)
+	ListFeedback(ctx context.Context, q FeedbackQuery) ([]Feedback, error)
+	CountFeedback(ctx context.Context, q FeedbackQuery) (int64, error)
+	CreateFeedback(ctx context.Context, f *Feedback) error
+	SetFeedbackModeration(ctx context.Context, id, state, note, rejectReason string) error
+	SetFeedbackHidden(ctx context.Context, id string, hidden bool) (changed bool, err error)
+	MergeFeedback(ctx context.Context, fromID, toID string) (finalID string, err error)
+	UnmergeFeedback(ctx context.Context, id string) error
+
+	ReplyByID(ctx context.Context, id string) (*Reply, error)
+	RepliesByFeedbackID(ctx context.Context, feedbackID string) ([]Reply, error)
+	RepliesByUserID(ctx context.Context, userID string) ([]Reply, error)
+	CreateReply(ctx context.Context, r *Reply) error
+	SetReplyHidden(ctx context.Context, id string, hidden bool) (changed bool, err error)
+
+	ImportData(ctx context.Context, d *exportData) error
+
+	Watch(ctx context.Context, feedbackID, userID string) error
+	Unwatch(ctx context.Context, feedbackID, userID string) error
+	IsWatching(ctx context.Context, feedbackID, userID string) (bool, error)
+	MarkWatchersUnread(ctx context.Context, feedbackID, exceptUserID string) error
+	MarkWatchRead(ctx context.Context, feedbackID, userID string) error
+	UnreadWatches(ctx context.Context, userID string) (map[string]bool, error)
+}
+
+// FeedbackQuery 描述一次反馈列表查询，零值字段表示不限制。
+type FeedbackQuery struct {
+	Square     bool     // 只要广场上可见的（公开、已发布、未隐藏、未合并）
+	UserID     string   // 某个用户写的
+	WatchedBy  string   // 某个用户关注的
+	Moderation []string // 审核状态在其中
+	Search     string   // 标题或正文包含，不区分大小写
+	TitleAny   []string // 标题包含任意一个词，不区分大小写
+	ExcludeID  string
+	Since      time.Time // created_at >= Since
+	Until      time.Time // created_at < Until
+	Oldest     bool      // 按时间正序，默认倒序
+	Limit      int       // 0 取默认 100 条，负数不限
+	Offset     int       // 跳过前面多少条，分页用
+}
+
+var errBadMergeTarget = errors.New("bad merge target")
+
+// sqlStore 是两种 SQL 后端共用的实现，方言差异由 *DB 处理。
+type sqlStore struct {
+	db *DB
+
+	// schemaFrom 是打开前库里记录的表结构版本，afterOpen 据此决定跑哪些一次性补全。
+	schemaFrom int
+}
+
+// feedbackSelect 是列表/详情共用的查询头，列顺序和 scanFeedback 对应。
+const feedbackSelect = `
+	SELECT f.id, f.title, f.content, f.excerpt, f.is_public, f.user_id, u.username, f.created_at, f.updated_at,
+		f.moderation, f.moderation_note, f.reject_reason, f.hidden, f.merged_into
+	FROM feedbacks f
+	JOIN users u ON u.id = f.user_id
+`
+
+// squareWhere 是「出现在广场上」的条件：公开、已发布、未隐藏、没被合并。
+const squareWhere = `f.is_public = 1 AND f.moderation = 'published' AND f.hidden = 0 AND f.merged_into = ''`
+
+func scanFeedback(sc interface{ Scan(dest ...any) error }) (Feedback, error) {
+	var f Feedback
+	var isPublic, hidden int64
+	var created, updated int64
+	err := sc.Scan(&f.ID, &f.Title, &f.Content, &f.Excerpt, &isPublic, &f.UserID, &f.Username, &created, &updated,
+		&f.Moderation, &f.ModerationNote, &f.RejectReason, &hidden, &f.MergedInto)
+	if err != nil {
+		return Feedback{}, err
+	}
+	f.IsPublic = isPublic == 1
+	f.Hidden = hidden == 1
+	f.CreatedAt = time.Unix(created, 0)
+	f.UpdatedAt = time.Unix(updated, 0)
+	return f, nil
+}
+
+const userSelect = `SELECT id, linux_do_id, username, avatar_url, created_at, deletion_requested_at, deleted_at FROM users `
+
+func scanUser(sc interface{ Scan(dest ...any) error }) (User, error) {
+	var u User
+	var avatar sql.NullString
+	var created, deletionRequested, deleted int64
+	if err := sc.Scan(&u.ID, &u.LinuxDoID, &u.Username, &avatar, &created, &deletionRequested, &deleted); err != nil {
+		return User{}, err
+	}
+	u.AvatarURL = avatar.String
+	u.CreatedAt = time.Unix(created, 0)
+	if deletionRequested > 0 {
+		u.DeletionRequestedAt = time.Unix(deletionRequested, 0)
+	}
+	if deleted > 0 {
+		u.DeletedAt = time.Unix(deleted, 0)
+	}
+	return u, nil
+}
+
+func (s *sqlStore) UserByID(ctx context.Context, id string) (*User, error) {
+	if strings.TrimSpace(id) == "" {
+		return nil, sql.ErrNoRows
+	}
+	u, err := scanUser(s.db.QueryRowContext(ctx, userSelect+`WHERE id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (s *sqlStore) UserByLinuxDoID(ctx context.Context, linuxDoID string) (*User, error) {
+	u, err := scanUser(s.db.QueryRowContext(ctx, userSelect+`WHERE linux_do_id = ?`, linuxDoID))
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// UserByUsername 按用户名找未注销的用户。
+func (s *sqlStore) UserByUsername(ctx context.Context, username string) (*User, error) {
+	u, err := scanUser(s.db.QueryRowContext(ctx, userSelect+`WHERE username = ? AND deleted_at = 0 LIMIT 1`, username))
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (s *sqlStore) ListUsers(ctx context.Context) ([]User, error) {
+	return s.queryUsers(ctx, userSelect+`ORDER BY created_at ASC`)
+}
+
+func (s *sqlStore) queryUsers(ctx context.Context, query string, args ...any) ([]User, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, u)
+	}
+	return list, rows.Err()
+}
+
+// execer 是 *DB 和 *Tx 共有的写方法，插入语句在单条写入和批量导入的事务里共用。
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func (s *sqlStore) CreateUser(ctx context.Context, u *User) error {
+	return insertUser(ctx, s.db, u)
+}
+
+func insertUser(ctx context.Context, db execer, u *User) error {
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO users(id, linux_do_id, username, avatar_url, created_at) VALUES(?,?,?,?,?)`,
+		u.ID, u.LinuxDoID, u.Username, nullIfEmpty(u.AvatarURL), u.CreatedAt.Unix(),
+	)
+	return err
+}
+
+func (s *sqlStore) UpsertUserByLinuxDoID(ctx context.Context, u LinuxDoUser) (string, error) {
+	// 多实例时两个回调可能同时进来，用 ON CONFLICT 一条语句搞定，不再先查后插。
+	// RETURNING 要写库，放进事务保证走写连接。
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var id string
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO users(id, linux_do_id, username, avatar_url, created_at) VALUES(?,?,?,?,?)
+		ON CONFLICT(linux_do_id) DO UPDATE SET username = excluded.username, avatar_url = excluded.avatar_url
+		RETURNING id
+	`, newID(), u.ID, u.Username, nullIfEmpty(u.AvatarURL), time.Now().Unix()).Scan(&id)
+	if err != nil {
+		return "", err
+	}
+	return id, tx.Commit()
+}
+
+// SetUserDeletionRequest 记录用户申请注销的时间；at 为零值表示撤销申请。
+func (s *sqlStore) SetUserDeletionRequest(ctx context.Context, id string, at time.Time) error {
+	var v int64
+	if !at.IsZero() {
+		v = at.Unix()
+	}
+	_, err := s.db.ExecContext(ctx, `UPDATE users SET deletion_requested_at = ? WHERE id = ? AND deleted_at = 0`, v, id)
+	return err
+}
+
+func (s *sqlStore) UsersDueForDeletion(ctx context.Context, requestedBefore time.Time) ([]User, error) {
+	return s.queryUsers(ctx, userSelect+`WHERE deletion_requested_at > 0 AND deletion_requested_at <= ? AND deleted_at = 0`, requestedBefore.Unix())
+}
+
+// DeleteUserData 注销账号：users 行保留（反馈、审计还引用着它），但清掉能识别本人的字段。
+// deleteFeedback 为真时连同其反馈、反馈下的回复和举报一起删掉，否则反馈留着、只是作者变成匿名。
+func (s *sqlStore) DeleteUserData(ctx context.Context, id string, deleteFeedback bool) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if deleteFeedback {
+		stmts := []string{
+			`DELETE FROM replies WHERE feedback_id IN (SELECT id FROM feedbacks WHERE user_id = ?)`,
+			`DELETE FROM reports WHERE feedback_id IN (SELECT id FROM feedbacks WHERE user_id = ?)`,
+			`DELETE FROM watches WHERE feedback_id IN (SELECT id FROM feedbacks WHERE user_id = ?)`,
+			`UPDATE feedbacks SET merged_into = '' WHERE merged_into IN (SELECT id FROM feedbacks WHERE user_id = ?)`,
+			`DELETE FROM feedbacks WHERE user_id = ?`,
+		}
+		for _, q := range stmts {
+			if _, err := tx.ExecContext(ctx, q, id); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM watches WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	// 举报里有举报人写的说明，两种策略下都删掉；未处理的举报人数会相应减少。
+	if _, err := tx.ExecContext(ctx, `DELETE FROM reports WHERE reporter_user_id = ?`, id); err != nil {
+		return err
+	}
+
+	// linux_do_id 有唯一约束，换成一个不会和真实 ID 撞的值；同一个人以后再登录会得到一个新账号。
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users SET linux_do_id = ?, username = ?, avatar_url = NULL, deletion_requested_at = 0, deleted_at = ?
+		WHERE id = ?
+	`, "deleted:"+id, deletedUsername, time.Now().Unix(), id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) FeedbackByID(ctx context.Context, id string) (*Feedback, error) {
+	f, err := scanFeedback(s.db.QueryRowContext(ctx, feedbackSelect+`WHERE f.id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func feedbackWhere(q FeedbackQuery) (string, []any) {
+	where := `WHERE 1 = 1`
+	args := []any{}
+	if q.Square {
+		where += ` AND ` + squareWhere
+	}
+	if q.UserID != "" {
+		where += ` AND f.user_id = ?`
+		args = append(args, q.UserID)
+	}
+	if q.WatchedBy != "" {
+		where += ` AND f.id IN (SELECT feedback_id FROM watches WHERE user_id = ?)`
+		args = append(args, q.WatchedBy)
+	}
+	if len(q.Moderation) > 0 {
+		where += ` AND f.moderation IN (?` + strings.Repeat(`, ?`, len(q.Moderation)-1) + `)`
+		for _, m := range q.Moderation {
+			args = append(args, m)
+		}
+	}
+	if q.Search != "" {
+		where += ` AND (LOWER(f.title) LIKE ? OR LOWER(f.content) LIKE ?)`
+		like := "%" + strings.ToLower(q.Search) + "%"
+		args = append(args, like, like)
+	}
+	if len(q.TitleAny) > 0 {
+		var ors []string
+		for _, t := range q.TitleAny {
+			ors = append(ors, `LOWER(f.title) LIKE ?`)
+			args = append(args, "%"+strings.ToLower(t)+"%")
+		}
+		where += ` AND (` + strings.Join(ors, " OR ") + `)`
+	}
+	if q.ExcludeID != "" {
+		where += ` AND f.id <> ?`
+		args = append(args, q.ExcludeID)
+	}
+	if !q.Since.IsZero() {
+		where += ` AND f.created_at >= ?`
+		args = append(args, q.Since.Unix())
+	}
+	if !q.Until.IsZero() {
+		where += ` AND f.created_at < ?`
+		args = append(args, q.Until.Unix())
+	}
+	return where, args
+}
+
+func (s *sqlStore) ListFeedback(ctx context.Context, q FeedbackQuery) ([]Feedback, error) {
+	where, args := feedbackWhere(q)
+	order := `DESC`
+	if q.Oldest {
+		order = `ASC`
+	}
+	query := feedbackSelect + where + `
+		ORDER BY f.created_at ` + order
+	switch {
+	case q.Limit == 0:
+		query += ` LIMIT 100`
+	case q.Limit > 0:
+		query += ` LIMIT ?`
+		args = append(args, q.Limit)
+	}
+	if q.Offset > 0 && q.Limit >= 0 {
+		query += ` OFFSET ?`
+		args = append(args, q.Offset)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Feedback
+	for rows.Next() {
+		f, err := scanFeedback(rows)
+		if err != nil {
+			slog.ErrorContext(ctx, "读取反馈行失败", "err", err)
+			continue
+		}
+		list = append(list, f)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlStore) CountFeedback(ctx context.Context, q FeedbackQuery) (int64, error) {
+	where, args := feedbackWhere(q)
+	var n int64
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(1) FROM feedbacks f `+where, args...).Scan(&n)
+	return n, err
+}
+
+// CreateFeedback 写入时顺便生成列表用的纯文本摘要。
+func (s *sqlStore) CreateFeedback(ctx context.Context, f *Feedback) error {
+	return insertFeedback(ctx, s.db, f)
+}
+
+func insertFeedback(ctx context.Context, db execer, f *Feedback) error {
+	f.Excerpt = plainExcerpt(f.Content)
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO feedbacks(id, title, content, excerpt, is_public, created_at, updated_at, user_id,
+			moderation, moderation_note, reject_reason, hidden, merged_into)
+		VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?)
+	`, f.ID, f.Title, f.Content, f.Excerpt, boolToInt(f.IsPublic), f.CreatedAt.Unix(), f.UpdatedAt.Unix(), f.UserID,
+		f.Moderation, f.ModerationNote, f.RejectReason, boolToInt(f.Hidden), f.MergedInto)
+	return err
+}
+
+func (s *sqlStore) SetFeedbackModeration(ctx context.Context, id, state, note, rejectReason string) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE feedbacks SET moderation = ?, moderation_note = ?, reject_reason = ? WHERE id = ?`,
+		state, note, rejectReason, id,
+	)
+	return err
+}
+
+func (s *sqlStore) SetFeedbackHidden(ctx context.Context, id string, hidden bool) (bool, error) {
+	return s.setHidden(ctx, "feedbacks", id, hidden)
+}
+
+func (s *sqlStore) SetReplyHidden(ctx context.Context, id string, hidden bool) (bool, error) {
+	return s.setHidden(ctx, "replies", id, hidden)
+}
+
+// setHidden 只在状态真的变化时写库，返回是否改动，方便调用方决定要不要记审计。
+func (s *sqlStore) setHidden(ctx context.Context, table, id string, hidden bool) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `UPDATE `+table+` SET hidden = ? WHERE id = ? AND hidden <> ?`,
+		boolToInt(hidden), id, boolToInt(hidden))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// MergeFeedback 把 fromID 标记为 toID 的重复。目标如果本身也被合并过，顺着链找到最终那条；
+// 已经合并到 fromID 的也一起改指向，保证只跳一次。返回最终目标 ID。
+// fromID 的关注者同时关注目标；原来的关注保留，撤销合并后也还在。
+func (s *sqlStore) MergeFeedback(ctx context.Context, fromID, toID string) (string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	for i := 0; ; i++ {
+		if toID == fromID || i > 10 {
+			return "", errBadMergeTarget
+		}
+		var next string
+		err := tx.QueryRowContext(ctx, `SELECT merged_into FROM feedbacks WHERE id = ?`+s.db.forUpdate(), toID).Scan(&next)
+		if err == sql.ErrNoRows {
+			return "", errBadMergeTarget
+		}
+		if err != nil {
+			return "", err
+		}
+		if next == "" {
+			break
+		}
+		toID = next
+	}
+
+	now := time.Now().Unix()
+	if _, err := tx.ExecContext(ctx, `UPDATE feedbacks SET merged_into = ?, updated_at = ? WHERE id = ?`, toID, now, fromID); err != nil {
+		return "", err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE feedbacks SET merged_into = ? WHERE merged_into = ?`, toID, fromID); err != nil {
+		return "", err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO watches(feedback_id, user_id, created_at)
+		SELECT ?, user_id, created_at FROM watches WHERE feedback_id = ?
+		ON CONFLICT(feedback_id, user_id) DO NOTHING
+	`, toID, fromID); err != nil {
+		return "", err
+	}
+	return toID, tx.Commit()
+}
+
+func (s *sqlStore) UnmergeFeedback(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE feedbacks SET merged_into = '' WHERE id = ?`, id)
+	return err
+}
+
+const replySelect = `
+	SELECT r.id, r.content, r.created_at, r.feedback_id, r.admin_user_id, COALESCE(u.username, ''), r.hidden
+	FROM replies r
+	LEFT JOIN users u ON u.id = r.admin_user_id
+`
+
+func scanReply(sc interface{ Scan(dest ...any) error }) (Reply, error) {
+	var it Reply
+	var created, hidden int64
+	var adminUserID sql.NullString
+	if err := sc.Scan(&it.ID, &it.Content, &created, &it.FeedbackID, &adminUserID, &it.AdminUsername, &hidden); err != nil {
+		return Reply{}, err
+	}
+	it.CreatedAt = time.Unix(created, 0)
+	it.AdminUserID = adminUserID.String
+	it.Hidden = hidden == 1
+	return it, nil
+}
+
+func (s *sqlStore) ReplyByID(ctx context.Context, id string) (*Reply, error) {
+	it, err := scanReply(s.db.QueryRowContext(ctx, replySelect+`WHERE r.id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+	return &it, nil
+}
+
+func (s *sqlStore) RepliesByFeedbackID(ctx context.Context, feedbackID string) ([]Reply, error) {
+	return s.queryReplies(ctx, replySelect+`WHERE r.feedback_id = ? ORDER BY r.created_at ASC`, feedbackID)
+}
+
+func (s *sqlStore) RepliesByUserID(ctx context.Context, userID string) ([]Reply, error) {
+	return s.queryReplies(ctx, replySelect+`WHERE r.admin_user_id = ? ORDER BY r.created_at ASC`, userID)
+}
+
+func (s *sqlStore) queryReplies(ctx context.Context, query string, args ...any) ([]Reply, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Reply
+	for rows.Next() {
+		it, err := scanReply(rows)
+		if err != nil {
+			slog.ErrorContext(ctx, "读取回复行失败", "err", err)
+			continue
+		}
+		list = append(list, it)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlStore) CreateReply(ctx context.Context, r *Reply) error {
+	return insertReply(ctx, s.db, r)
+}
+
+func insertReply(ctx context.Context, db execer, r *Reply) error {
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO replies(id, content, created_at, feedback_id, admin_user_id, hidden) VALUES(?,?,?,?,?,?)`,
+		r.ID, r.Content, r.CreatedAt.Unix(), r.FeedbackID, nullIfEmpty(r.AdminUserID), boolToInt(r.Hidden),
+	)
+	return err
+}
+
+// ImportData 在一个事务里按用户、反馈、回复的顺序写入一批数据，任何一条失败都整体回滚，
+// 不会留下导入了一半的库。
+func (s *sqlStore) ImportData(ctx context.Context, d *exportData) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i := range d.Users {
+		if err := insertUser(ctx, tx, &d.Users[i]); err != nil {
+			return fmt.Errorf("写入用户 %s: %w", d.Users[i].ID, err)
+		}
+	}
+	for i := range d.Feedback {
+		if err := insertFeedback(ctx, tx, &d.Feedback[i]); err != nil {
+			return fmt.Errorf("写入反馈 %s: %w", d.Feedback[i].ID, err)
+		}
+	}
+	for i := range d.Replies {
+		if err := insertReply(ctx, tx, &d.Replies[i]); err != nil {
+			return fmt.Errorf("写入回复 %s: %w", d.Replies[i].ID, err)
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// postgresStore 用于多实例部署：多个进程共用一个库，挂在负载均衡后面。
+type postgresStore struct {
+	*sqlStore
+}
+
+func openPostgresStore(url string) (*postgresStore, error) {
+	db, err := sql.Open("pgx", url)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(20)
+	db.SetMaxIdleConns(5)
+	db.SetConnMaxLifetime(30 * time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	stored, err := ensurePostgresSchema(ctx, db)
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("初始化数据库结构失败: %w", err)
+	}
+	return &postgresStore{&sqlStore{db: &DB{DB: db, dialect: dialectPostgres}, schemaFrom: stored}}, nil
+}
+
+// postgresSchemaLock 是建表时用的 advisory lock 键，避免多个实例同时启动时抢着改表。
+const postgresSchemaLock = 7_202_601
+
+// ensurePostgresSchema 按 schema.go 里的清单建表，和 ensureSQLiteSchema 共用同一份定义，
+// 整个过程在一个事务里，并用 advisory lock 串行化。返回建表前库里记录的表结构版本。
+func ensurePostgresSchema(ctx context.Context, db *sql.DB) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, postgresSchemaLock); err != nil {
+		return 0, err
+	}
+
+	for _, s := range dialectPostgres.schemaStatements() {
+		if _, err := tx.ExecContext(ctx, s); err != nil {
+			return 0, err
+		}
+	}
+
+	// 老库的 reports 表上有整表的唯一约束（处理过的举报也算），换成 schemaLate 里只管未处理举报的部分唯一索引。
+	if _, err := tx.ExecContext(ctx, `ALTER TABLE reports DROP CONSTRAINT IF EXISTS reports_target_type_target_id_reporter_user_id_key`); err != nil {
+		return 0, err
+	}
+
+	for _, c := range schemaColumns {
+		q := fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s`, c.table, c.name, dialectPostgres.ddl(c.decl))
+		if _, err := tx.ExecContext(ctx, q); err != nil {
+			return 0, err
+		}
+	}
+
+	stored, err := readSchemaVersion(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+	for _, s := range dialectPostgres.lateStatements(stored) {
+		if _, err := tx.ExecContext(ctx, s); err != nil {
+			return 0, err
+		}
+	}
+	return stored, tx.Commit()
+}
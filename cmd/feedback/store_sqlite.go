@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"runtime"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteStore 是默认的单机存储。
+type sqliteStore struct {
+	*sqlStore
+}
+
+// openSQLiteStore 打开一条写连接和一个只读连接池。WAL 模式下读不会被写挡住，
+// 但 SQLite 同一时间只能有一个写者，所以写连接只开一条，写操作在 Go 这边排队，
+// 不会在库里互相抢锁报 SQLITE_BUSY。
+func openSQLiteStore(path string) (*sqliteStore, error) {
+	// modernc sqlite DSN 走 file: 前缀最稳。
+	// synchronous=NORMAL 在 WAL 下是安全的（断电最多丢最后几个事务，不会损坏库）；
+	// _txlock=immediate 让事务一开始就拿写锁，避免读事务中途升级写锁时失败。
+	wdsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_pragma=synchronous(NORMAL)&_txlock=immediate", path)
+	wdb, err := sql.Open("sqlite", wdsn)
+	if err != nil {
+		return nil, err
+	}
+	wdb.SetMaxOpenConns(1)
+	wdb.SetMaxIdleConns(1)
+	wdb.SetConnMaxLifetime(0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := wdb.PingContext(ctx); err != nil {
+		_ = wdb.Close()
+		return nil, err
+	}
+	// 建表要在打开只读连接之前：新库第一次启动时文件和 WAL 都是这里创建的。
+	stored, err := ensureSQLiteSchema(wdb)
+	if err != nil {
+		_ = wdb.Close()
+		return nil, fmt.Errorf("初始化数据库结构失败: %w", err)
+	}
+
+	rdsn := fmt.Sprintf("file:%s?mode=ro&_pragma=busy_timeout(5000)", path)
+	rdb, err := sql.Open("sqlite", rdsn)
+	if err != nil {
+		_ = wdb.Close()
+		return nil, err
+	}
+	n := max(4, runtime.NumCPU())
+	rdb.SetMaxOpenConns(n)
+	rdb.SetMaxIdleConns(n)
+	if err := rdb.PingContext(ctx); err != nil {
+		_ = rdb.Close()
+		_ = wdb.Close()
+		return nil, err
+	}
+
+	return &sqliteStore{&sqlStore{db: &DB{DB: wdb, read: rdb, dialect: dialectSQLite}, schemaFrom: stored}}, nil
+}
+
+// ensureSQLiteSchema 按 schema.go 里的清单建表，返回建表前库里记录的表结构版本。
+func ensureSQLiteSchema(db *sql.DB) (int, error) {
+	if err := rebuildSQLiteReports(db); err != nil {
+		return 0, fmt.Errorf("迁移 reports 表失败: %w", err)
+	}
+	for _, s := range dialectSQLite.schemaStatements() {
+		if _, err := db.Exec(s); err != nil {
+			return 0, err
+		}
+	}
+
+	// 老库上补列：SQLite 没有 ADD COLUMN IF NOT EXISTS，只能先查再加。
+	for _, c := range schemaColumns {
+		if err := ensureColumn(db, c.table, c.name, dialectSQLite.ddl(c.decl)); err != nil {
+			return 0, err
+		}
+	}
+
+	stored, err := readSchemaVersion(context.Background(), db)
+	if err != nil {
+		return 0, err
+	}
+	for _, s := range dialectSQLite.lateStatements(stored) {
+		if _, err := db.Exec(s); err != nil {
+			return 0, err
+		}
+	}
+	return stored, nil
+}
+
+// rebuildSQLiteReports 去掉老库 reports 表上整表的唯一约束（处理过的举报也算在内，同一个人就再也举报不了）。
+// SQLite 删不了表上的约束，只能按新定义重建这张表再把数据搬过去；索引随后由建表语句重新建好。
+func rebuildSQLiteReports(db *sql.DB) error {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(1) FROM pragma_index_list('reports') WHERE origin = 'u'`).Scan(&n)
+	if err != nil || n == 0 {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmts := []string{
+		`ALTER TABLE reports RENAME TO reports_old`,
+		dialectSQLite.ddl(reportsTable),
+		`INSERT INTO reports(id, target_type, target_id, feedback_id, reporter_user_id, reason, detail, created_at, resolved_at)
+		SELECT id, target_type, target_id, feedback_id, reporter_user_id, reason, detail, created_at, resolved_at FROM reports_old`,
+		`DROP TABLE reports_old`,
+	}
+	for _, s := range stmts {
+		if _, err := tx.Exec(s); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func ensureColumn(db *sql.DB, table, column, decl string) error {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(1) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	_, err = db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, decl))
+	return err
+}
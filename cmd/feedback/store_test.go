@@ -0,0 +1,721 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+var (
+	_ Store = (*sqliteStore)(nil)
+	_ Store = (*postgresStore)(nil)
+)
+
+// forEachStore 对每种后端各跑一遍 fn：SQLite 总是跑；设置了 TEST_DATABASE_URL（postgres://…）时
+// 再在 PostgreSQL 上跑一遍，每个测试用一个临时 schema，跑完删掉。
+func forEachStore(t *testing.T, fn func(t *testing.T, s *sqlStore)) {
+	t.Run("sqlite", func(t *testing.T) {
+		fn(t, openTestSQLite(t))
+	})
+	t.Run("postgres", func(t *testing.T) {
+		base := os.Getenv("TEST_DATABASE_URL")
+		if base == "" {
+			t.Skip("没有设置 TEST_DATABASE_URL")
+		}
+		fn(t, openTestPostgres(t, base))
+	})
+}
+
+func openTestSQLite(tb testing.TB) *sqlStore {
+	tb.Helper()
+	s, err := openSQLiteStore(filepath.Join(tb.TempDir(), "test.db"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { _ = s.db.Close() })
+	if err := afterOpen(s.sqlStore); err != nil {
+		tb.Fatal(err)
+	}
+	return s.sqlStore
+}
+
+func openTestPostgres(tb testing.TB, base string) *sqlStore {
+	tb.Helper()
+	admin, err := sql.Open("pgx", base)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { _ = admin.Close() })
+
+	schema := "test_" + newID()
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { _, _ = admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`) })
+
+	u, err := url.Parse(base)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+
+	s, err := openPostgresStore(u.String())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { _ = s.db.Close() })
+	if err := afterOpen(s.sqlStore); err != nil {
+		tb.Fatal(err)
+	}
+	return s.sqlStore
+}
+
+// 测试数据的时间都以 testEpoch 为起点按秒错开，列表排序结果是确定的。
+var testEpoch = time.Unix(1_700_000_000, 0)
+
+func addUser(t testing.TB, s *sqlStore, name string) *User {
+	t.Helper()
+	u := &User{ID: newID(), LinuxDoID: "ld-" + name, Username: name, CreatedAt: testEpoch}
+	if err := s.CreateUser(context.Background(), u); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func addFeedback(t testing.TB, s *sqlStore, userID, title string, at int, edit func(f *Feedback)) *Feedback {
+	t.Helper()
+	ts := testEpoch.Add(time.Duration(at) * time.Second)
+	f := &Feedback{
+		ID: newID(), Title: title, Content: "# " + title + "\n\n正文 **" + title + "**",
+		IsPublic: true, UserID: userID, CreatedAt: ts, UpdatedAt: ts, Moderation: moderationPublished,
+	}
+	if edit != nil {
+		edit(f)
+	}
+	if err := s.CreateFeedback(context.Background(), f); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func addReply(t *testing.T, s *sqlStore, feedbackID, adminID string, at int) *Reply {
+	t.Helper()
+	r := &Reply{ID: newID(), Content: "回复", CreatedAt: testEpoch.Add(time.Duration(at) * time.Second), FeedbackID: feedbackID, AdminUserID: adminID}
+	if err := s.CreateReply(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func feedbackIDs(list []Feedback) []string {
+	var ids []string
+	for _, f := range list {
+		ids = append(ids, f.ID)
+	}
+	return ids
+}
+
+func TestStoreUsers(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *sqlStore) {
+		ctx := context.Background()
+		alice := addUser(t, s, "alice")
+		addUser(t, s, "bob")
+
+		got, err := s.UserByID(ctx, alice.ID)
+		if err != nil || got.Username != "alice" || got.LinuxDoID != "ld-alice" || !got.CreatedAt.Equal(testEpoch) {
+			t.Fatalf("UserByID = %+v, %v", got, err)
+		}
+		if _, err := s.UserByID(ctx, ""); !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("UserByID(\"\") err = %v", err)
+		}
+		if got, err := s.UserByLinuxDoID(ctx, "ld-alice"); err != nil || got.ID != alice.ID {
+			t.Fatalf("UserByLinuxDoID = %+v, %v", got, err)
+		}
+		if got, err := s.UserByUsername(ctx, "alice"); err != nil || got.ID != alice.ID {
+			t.Fatalf("UserByUsername = %+v, %v", got, err)
+		}
+		users, err := s.ListUsers(ctx)
+		if err != nil || len(users) != 2 {
+			t.Fatalf("ListUsers = %d, %v", len(users), err)
+		}
+
+		// 同一个 Linux DO 账号再次登录：更新资料，ID 不变。
+		id, err := s.UpsertUserByLinuxDoID(ctx, LinuxDoUser{ID: "ld-carol", Username: "carol", AvatarURL: "https://a/1.png"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		id2, err := s.UpsertUserByLinuxDoID(ctx, LinuxDoUser{ID: "ld-carol", Username: "carol2"})
+		if err != nil || id2 != id {
+			t.Fatalf("upsert again = %q, %v; want %q", id2, err, id)
+		}
+		if got, _ := s.UserByID(ctx, id); got.Username != "carol2" || got.AvatarURL != "" {
+			t.Fatalf("after upsert = %+v", got)
+		}
+
+		at := testEpoch.Add(time.Hour)
+		if err := s.SetUserDeletionRequest(ctx, alice.ID, at); err != nil {
+			t.Fatal(err)
+		}
+		due, err := s.UsersDueForDeletion(ctx, at)
+		if err != nil || len(due) != 1 || due[0].ID != alice.ID || !due[0].DeletionRequestedAt.Equal(at) {
+			t.Fatalf("UsersDueForDeletion = %+v, %v", due, err)
+		}
+		if due, _ := s.UsersDueForDeletion(ctx, at.Add(-time.Second)); len(due) != 0 {
+			t.Fatalf("not yet due, got %d", len(due))
+		}
+		if err := s.SetUserDeletionRequest(ctx, alice.ID, time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+		if due, _ := s.UsersDueForDeletion(ctx, at); len(due) != 0 {
+			t.Fatalf("cancelled request still due: %d", len(due))
+		}
+	})
+}
+
+func TestStoreDeleteUserData(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *sqlStore) {
+		ctx := context.Background()
+		alice := addUser(t, s, "alice")
+		admin := addUser(t, s, "admin")
+		kept := addFeedback(t, s, alice.ID, "保留", 1, nil)
+		if err := s.Watch(ctx, kept.ID, alice.ID); err != nil {
+			t.Fatal(err)
+		}
+		reported := addFeedback(t, s, admin.ID, "被举报", 1, nil)
+		if _, err := s.db.ExecContext(ctx, `
+			INSERT INTO reports(id, target_type, target_id, feedback_id, reporter_user_id, reason, detail, created_at)
+			VALUES(?,?,?,?,?,?,?,?)
+		`, newID(), reportTargetFeedback, reported.ID, reported.ID, alice.ID, "spam", "举报说明", testEpoch.Unix()); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.DeleteUserData(ctx, alice.ID, false); err != nil {
+			t.Fatal(err)
+		}
+		u, err := s.UserByID(ctx, alice.ID)
+		if err != nil || !u.Deleted() || u.Username != deletedUsername || u.LinuxDoID == "ld-alice" {
+			t.Fatalf("after delete = %+v, %v", u, err)
+		}
+		if _, err := s.UserByUsername(ctx, deletedUsername); !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("deleted user found by name: %v", err)
+		}
+		if f, err := s.FeedbackByID(ctx, kept.ID); err != nil || !f.AuthorDeleted() {
+			t.Fatalf("kept feedback = %+v, %v", f, err)
+		}
+		if ok, _ := s.IsWatching(ctx, kept.ID, alice.ID); ok {
+			t.Fatal("watch not removed")
+		}
+		var reports int
+		if err := s.db.QueryRowContext(ctx, `SELECT COUNT(1) FROM reports WHERE reporter_user_id = ?`, alice.ID).Scan(&reports); err != nil || reports != 0 {
+			t.Fatalf("reports by deleted user = %d, %v", reports, err)
+		}
+
+		bob := addUser(t, s, "bob")
+		gone := addFeedback(t, s, bob.ID, "删除", 2, nil)
+		dup := addFeedback(t, s, admin.ID, "重复", 3, func(f *Feedback) { f.MergedInto = gone.ID })
+		reply := addReply(t, s, gone.ID, admin.ID, 4)
+		if err := s.DeleteUserData(ctx, bob.ID, true); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.FeedbackByID(ctx, gone.ID); !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("feedback not deleted: %v", err)
+		}
+		if _, err := s.ReplyByID(ctx, reply.ID); !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("reply not deleted: %v", err)
+		}
+		if f, _ := s.FeedbackByID(ctx, dup.ID); f.MergedInto != "" {
+			t.Fatalf("merge pointer not cleared: %q", f.MergedInto)
+		}
+	})
+}
+
+func TestStoreListFeedback(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *sqlStore) {
+		ctx := context.Background()
+		alice := addUser(t, s, "alice")
+		bob := addUser(t, s, "bob")
+		a1 := addFeedback(t, s, alice.ID, "登录失败", 1, nil)
+		a2 := addFeedback(t, s, alice.ID, "私有问题", 2, func(f *Feedback) { f.IsPublic = false })
+		b1 := addFeedback(t, s, bob.ID, "页面加载慢", 3, nil)
+		b2 := addFeedback(t, s, bob.ID, "待审核的", 4, func(f *Feedback) { f.Moderation = moderationPending })
+		b3 := addFeedback(t, s, bob.ID, "被隐藏", 5, func(f *Feedback) { f.Hidden = true })
+
+		f, err := s.FeedbackByID(ctx, a1.ID)
+		if err != nil || f.Username != "alice" || f.Excerpt != "登录失败 正文 登录失败" || !f.IsPublic || !f.CreatedAt.Equal(a1.CreatedAt) {
+			t.Fatalf("FeedbackByID = %+v, %v", f, err)
+		}
+		if _, err := s.FeedbackByID(ctx, "nope"); !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("missing feedback err = %v", err)
+		}
+
+		cases := []struct {
+			name string
+			q    FeedbackQuery
+			want []string
+		}{
+			{"all", FeedbackQuery{}, []string{b3.ID, b2.ID, b1.ID, a2.ID, a1.ID}},
+			{"square", FeedbackQuery{Square: true}, []string{b1.ID, a1.ID}},
+			{"user", FeedbackQuery{UserID: alice.ID}, []string{a2.ID, a1.ID}},
+			{"moderation", FeedbackQuery{Moderation: []string{moderationPending, moderationFlagged}}, []string{b2.ID}},
+			{"search", FeedbackQuery{Search: "加载"}, []string{b1.ID}},
+			{"title any", FeedbackQuery{TitleAny: []string{"登录", "隐藏"}}, []string{b3.ID, a1.ID}},
+			{"exclude", FeedbackQuery{Square: true, ExcludeID: b1.ID}, []string{a1.ID}},
+			{"range", FeedbackQuery{Since: a2.CreatedAt, Until: b2.CreatedAt}, []string{b1.ID, a2.ID}},
+			{"oldest", FeedbackQuery{Oldest: true, Limit: 2}, []string{a1.ID, a2.ID}},
+			{"page", FeedbackQuery{Limit: 2, Offset: 2}, []string{b1.ID, a2.ID}},
+			{"unlimited", FeedbackQuery{Limit: -1, UserID: bob.ID}, []string{b3.ID, b2.ID, b1.ID}},
+		}
+		for _, c := range cases {
+			list, err := s.ListFeedback(ctx, c.q)
+			if err != nil {
+				t.Fatalf("%s: %v", c.name, err)
+			}
+			if got := feedbackIDs(list); !slices.Equal(got, c.want) {
+				t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+			}
+			q := c.q
+			q.Limit, q.Offset, q.Oldest = 0, 0, false
+			want, _ := s.ListFeedback(ctx, q)
+			if n, err := s.CountFeedback(ctx, q); err != nil || n != int64(len(want)) {
+				t.Errorf("%s: CountFeedback = %d, %v; want %d", c.name, n, err, len(want))
+			}
+		}
+	})
+}
+
+func TestStoreModeration(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *sqlStore) {
+		ctx := context.Background()
+		alice := addUser(t, s, "alice")
+		f := addFeedback(t, s, alice.ID, "待审", 1, func(f *Feedback) {
+			f.Moderation, f.ModerationNote = moderationFlagged, "链接过多"
+		})
+
+		if err := s.SetFeedbackModeration(ctx, f.ID, moderationRejected, "链接过多", "广告"); err != nil {
+			t.Fatal(err)
+		}
+		got, _ := s.FeedbackByID(ctx, f.ID)
+		if got.Moderation != moderationRejected || got.ModerationNote != "链接过多" || got.RejectReason != "广告" {
+			t.Fatalf("after moderation = %+v", got)
+		}
+
+		for i, want := range []bool{true, false} {
+			changed, err := s.SetFeedbackHidden(ctx, f.ID, true)
+			if err != nil || changed != want {
+				t.Fatalf("SetFeedbackHidden #%d = %v, %v; want %v", i, changed, err, want)
+			}
+		}
+		if got, _ := s.FeedbackByID(ctx, f.ID); !got.Hidden {
+			t.Fatal("not hidden")
+		}
+		if changed, err := s.SetFeedbackHidden(ctx, f.ID, false); err != nil || !changed {
+			t.Fatalf("unhide = %v, %v", changed, err)
+		}
+	})
+}
+
+func TestStoreMerge(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *sqlStore) {
+		ctx := context.Background()
+		alice := addUser(t, s, "alice")
+		bob := addUser(t, s, "bob")
+		a := addFeedback(t, s, alice.ID, "A", 1, nil)
+		b := addFeedback(t, s, alice.ID, "B", 2, nil)
+		c := addFeedback(t, s, alice.ID, "C", 3, nil)
+		if err := s.Watch(ctx, b.ID, bob.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		// C -> B，再把 B 合并到 A：C 也改指 A，B 的关注者同时关注 A。
+		if id, err := s.MergeFeedback(ctx, c.ID, b.ID); err != nil || id != b.ID {
+			t.Fatalf("merge c->b = %q, %v", id, err)
+		}
+		if id, err := s.MergeFeedback(ctx, b.ID, a.ID); err != nil || id != a.ID {
+			t.Fatalf("merge b->a = %q, %v", id, err)
+		}
+		if got, _ := s.FeedbackByID(ctx, c.ID); got.MergedInto != a.ID {
+			t.Fatalf("c merged into %q, want %q", got.MergedInto, a.ID)
+		}
+		if ok, _ := s.IsWatching(ctx, a.ID, bob.ID); !ok {
+			t.Fatal("watcher not copied to merge target")
+		}
+
+		// 目标是自己、目标顺着链回到自己、目标不存在，都不行。
+		for _, to := range []string{a.ID, b.ID, "nope"} {
+			if _, err := s.MergeFeedback(ctx, a.ID, to); !errors.Is(err, errBadMergeTarget) {
+				t.Errorf("merge a->%s err = %v", to, err)
+			}
+		}
+
+		// 合并到链上的中间一条时，顺着找到最终目标。
+		d := addFeedback(t, s, alice.ID, "D", 4, nil)
+		if id, err := s.MergeFeedback(ctx, d.ID, b.ID); err != nil || id != a.ID {
+			t.Fatalf("merge d->b = %q, %v; want %q", id, err, a.ID)
+		}
+
+		if err := s.UnmergeFeedback(ctx, b.ID); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := s.FeedbackByID(ctx, b.ID); got.MergedInto != "" {
+			t.Fatalf("unmerge left %q", got.MergedInto)
+		}
+		if ok, _ := s.IsWatching(ctx, b.ID, bob.ID); !ok {
+			t.Fatal("original watch lost")
+		}
+	})
+}
+
+func TestStoreReplies(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *sqlStore) {
+		ctx := context.Background()
+		alice := addUser(t, s, "alice")
+		admin := addUser(t, s, "admin")
+		f := addFeedback(t, s, alice.ID, "问题", 1, nil)
+		r2 := addReply(t, s, f.ID, admin.ID, 3)
+		r1 := addReply(t, s, f.ID, admin.ID, 2)
+		sys := addReply(t, s, f.ID, "", 4)
+
+		got, err := s.ReplyByID(ctx, r1.ID)
+		if err != nil || got.AdminUsername != "admin" || got.FeedbackID != f.ID || !got.CreatedAt.Equal(r1.CreatedAt) {
+			t.Fatalf("ReplyByID = %+v, %v", got, err)
+		}
+		if got, err := s.ReplyByID(ctx, sys.ID); err != nil || got.AdminUserID != "" || got.AdminUsername != "" {
+			t.Fatalf("reply without admin = %+v, %v", got, err)
+		}
+
+		list, err := s.RepliesByFeedbackID(ctx, f.ID)
+		if err != nil || len(list) != 3 || list[0].ID != r1.ID || list[1].ID != r2.ID {
+			t.Fatalf("RepliesByFeedbackID = %+v, %v", list, err)
+		}
+		list, err = s.RepliesByUserID(ctx, admin.ID)
+		if err != nil || len(list) != 2 {
+			t.Fatalf("RepliesByUserID = %d, %v", len(list), err)
+		}
+
+		if changed, err := s.SetReplyHidden(ctx, r1.ID, true); err != nil || !changed {
+			t.Fatalf("SetReplyHidden = %v, %v", changed, err)
+		}
+		if changed, _ := s.SetReplyHidden(ctx, r1.ID, true); changed {
+			t.Fatal("hiding twice reported a change")
+		}
+		if got, _ := s.ReplyByID(ctx, r1.ID); !got.Hidden {
+			t.Fatal("reply not hidden")
+		}
+	})
+}
+
+func TestStoreWatches(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *sqlStore) {
+		ctx := context.Background()
+		alice := addUser(t, s, "alice")
+		bob := addUser(t, s, "bob")
+		carol := addUser(t, s, "carol")
+		f := addFeedback(t, s, alice.ID, "问题", 1, nil)
+		other := addFeedback(t, s, alice.ID, "别的", 2, nil)
+
+		for _, u := range []*User{alice, bob, bob, carol} {
+			if err := s.Watch(ctx, f.ID, u.ID); err != nil {
+				t.Fatalf("Watch %s: %v", u.Username, err)
+			}
+		}
+		if ok, err := s.IsWatching(ctx, f.ID, bob.ID); err != nil || !ok {
+			t.Fatalf("IsWatching = %v, %v", ok, err)
+		}
+		if ok, _ := s.IsWatching(ctx, other.ID, bob.ID); ok {
+			t.Fatal("watching unrelated feedback")
+		}
+		if list, _ := s.ListFeedback(ctx, FeedbackQuery{WatchedBy: bob.ID}); !slices.Equal(feedbackIDs(list), []string{f.ID}) {
+			t.Fatalf("WatchedBy = %v", feedbackIDs(list))
+		}
+
+		// 已注销的用户不再收到通知。
+		if err := s.DeleteUserData(ctx, carol.ID, false); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.MarkWatchersUnread(ctx, f.ID, alice.ID); err != nil {
+			t.Fatal(err)
+		}
+		if m, err := s.UnreadWatches(ctx, bob.ID); err != nil || !m[f.ID] {
+			t.Fatalf("bob unread = %v, %v", m, err)
+		}
+		if m, _ := s.UnreadWatches(ctx, alice.ID); len(m) != 0 {
+			t.Fatalf("actor marked unread: %v", m)
+		}
+		if m, _ := s.UnreadWatches(ctx, carol.ID); len(m) != 0 {
+			t.Fatalf("deleted user marked unread: %v", m)
+		}
+		if err := s.MarkWatchRead(ctx, f.ID, bob.ID); err != nil {
+			t.Fatal(err)
+		}
+		if m, _ := s.UnreadWatches(ctx, bob.ID); len(m) != 0 {
+			t.Fatalf("still unread: %v", m)
+		}
+
+		if err := s.Unwatch(ctx, f.ID, bob.ID); err != nil {
+			t.Fatal(err)
+		}
+		if ok, _ := s.IsWatching(ctx, f.ID, bob.ID); ok {
+			t.Fatal("still watching after Unwatch")
+		}
+	})
+}
+
+func TestStoreImportData(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *sqlStore) {
+		ctx := context.Background()
+		u := User{ID: "u1", LinuxDoID: "1", Username: "alice", CreatedAt: testEpoch}
+		f := Feedback{ID: "f1", Title: "t", Content: "**c**", IsPublic: true, UserID: "u1", CreatedAt: testEpoch, UpdatedAt: testEpoch, Moderation: moderationPublished}
+		r := Reply{ID: "r1", Content: "ok", CreatedAt: testEpoch, FeedbackID: "f1"}
+
+		// 最后一条回复的 ID 重复，整批都不能写进去。
+		bad := &exportData{Users: []User{u}, Feedback: []Feedback{f}, Replies: []Reply{r, r}}
+		if err := s.ImportData(ctx, bad); err == nil {
+			t.Fatal("duplicate reply imported")
+		}
+		if _, err := s.UserByID(ctx, "u1"); !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("user left behind after rollback: %v", err)
+		}
+
+		if err := s.ImportData(ctx, &exportData{Users: []User{u}, Feedback: []Feedback{f}, Replies: []Reply{r}}); err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.FeedbackByID(ctx, "f1")
+		if err != nil || got.Excerpt != "c" {
+			t.Fatalf("imported feedback = %+v, %v", got, err)
+		}
+		if got, err := s.ReplyByID(ctx, "r1"); err != nil || got.FeedbackID != "f1" {
+			t.Fatalf("imported reply = %+v, %v", got, err)
+		}
+	})
+}
+
+// TestSchemaReopen 在已有的库上再跑一遍建表：补列、建索引都要能重复执行。
+// 版本号在 afterOpen 的数据补全做完之后才写。
+func TestSchemaReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	for i := 0; i < 2; i++ {
+		s, err := openSQLiteStore(path)
+		if err != nil {
+			t.Fatalf("open #%d: %v", i, err)
+		}
+		want := 0
+		if i > 0 {
+			want = schemaVersion
+		}
+		if s.schemaFrom != want {
+			t.Fatalf("open #%d: schemaFrom = %d, want %d", i, s.schemaFrom, want)
+		}
+		err = afterOpen(s.sqlStore)
+		v, verr := s.db.storedSchemaVersion(context.Background())
+		_ = s.db.Close()
+		if err != nil || verr != nil || v != schemaVersion {
+			t.Fatalf("open #%d: schema version = %d, %v, %v", i, v, err, verr)
+		}
+	}
+}
+
+// TestSchemaWatchBackfill 检查作者关注的补全只在升级跨过版本 7 时跑一次。
+func TestSchemaWatchBackfill(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *sqlStore) {
+		ctx := context.Background()
+		migrate := func() {
+			t.Helper()
+			var err error
+			if s.db.dialect == dialectPostgres {
+				s.schemaFrom, err = ensurePostgresSchema(ctx, s.db.DB)
+			} else {
+				s.schemaFrom, err = ensureSQLiteSchema(s.db.DB)
+			}
+			if err == nil {
+				err = afterOpen(s)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		alice := addUser(t, s, "alice")
+		bob := addUser(t, s, "bob")
+		old := addFeedback(t, s, alice.ID, "老反馈", 1, nil)
+		gone := addFeedback(t, s, bob.ID, "已注销作者的", 2, nil)
+		if err := s.DeleteUserData(ctx, bob.ID, false); err != nil {
+			t.Fatal(err)
+		}
+
+		// 装作从版本 6 升级上来。
+		if _, err := s.db.ExecContext(ctx, `UPDATE schema_version SET version = 6 WHERE id = 1`); err != nil {
+			t.Fatal(err)
+		}
+		migrate()
+		if ok, err := s.IsWatching(ctx, old.ID, alice.ID); err != nil || !ok {
+			t.Fatalf("author not backfilled: %v, %v", ok, err)
+		}
+		if ok, _ := s.IsWatching(ctx, gone.ID, bob.ID); ok {
+			t.Fatal("deleted author backfilled")
+		}
+
+		// 之后取消的关注，再启动也不会被加回来。
+		if err := s.Unwatch(ctx, old.ID, alice.ID); err != nil {
+			t.Fatal(err)
+		}
+		migrate()
+		if ok, _ := s.IsWatching(ctx, old.ID, alice.ID); ok {
+			t.Fatal("backfill ran again")
+		}
+	})
+}
+
+// TestExcerptBackfillRunsOnce 检查摘要补全只在从版本 3 之前升级时跑，
+// 只有代码块、摘要为空的反馈不会让它每次启动都再扫一遍。
+func TestExcerptBackfillRunsOnce(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *sqlStore) {
+		ctx := context.Background()
+		alice := addUser(t, s, "alice")
+		text := addFeedback(t, s, alice.ID, "文字", 1, nil)
+		code := addFeedback(t, s, alice.ID, "代码", 2, func(f *Feedback) { f.Content = "```\nx := 1\n```" })
+		excerpt := func(id string) string {
+			t.Helper()
+			f, err := s.FeedbackByID(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return f.Excerpt
+		}
+		if excerpt(code.ID) != "" {
+			t.Fatalf("code-only excerpt = %q", excerpt(code.ID))
+		}
+		clear := func() {
+			t.Helper()
+			if _, err := s.db.ExecContext(ctx, `UPDATE feedbacks SET excerpt = '' WHERE id = ?`, text.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// 从加 excerpt 列之前的版本升级：补上。
+		clear()
+		s.schemaFrom = 2
+		if err := afterOpen(s); err != nil {
+			t.Fatal(err)
+		}
+		if excerpt(text.ID) == "" {
+			t.Fatal("excerpt not backfilled")
+		}
+
+		// 已经是当前版本：不再扫。
+		clear()
+		if err := afterOpen(s); err != nil {
+			t.Fatal(err)
+		}
+		if got := excerpt(text.ID); got != "" {
+			t.Fatalf("backfill ran again: %q", got)
+		}
+	})
+}
+
+// TestSchemaDialectsMatch 检查两种库建出来的表和列完全一致（需要 TEST_DATABASE_URL）。
+func TestSchemaDialectsMatch(t *testing.T) {
+	base := os.Getenv("TEST_DATABASE_URL")
+	if base == "" {
+		t.Skip("没有设置 TEST_DATABASE_URL")
+	}
+	lite := schemaColumnsOf(t, openTestSQLite(t).db, `
+		SELECT m.name, p.name FROM sqlite_master m, pragma_table_info(m.name) p
+		WHERE m.type = 'table' AND m.name NOT LIKE 'sqlite_%'
+	`)
+	pg := schemaColumnsOf(t, openTestPostgres(t, base).db, `
+		SELECT table_name, column_name FROM information_schema.columns WHERE table_schema = current_schema()
+	`)
+	if len(lite) == 0 {
+		t.Fatal("no tables")
+	}
+	for table, cols := range lite {
+		if !slices.Equal(cols, pg[table]) {
+			t.Errorf("%s: sqlite %v, postgres %v", table, cols, pg[table])
+		}
+	}
+	for table := range pg {
+		if _, ok := lite[table]; !ok {
+			t.Errorf("%s: only in postgres", table)
+		}
+	}
+}
+
+func schemaColumnsOf(t *testing.T, db *DB, query string) map[string][]string {
+	t.Helper()
+	rows, err := db.QueryContext(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	m := map[string][]string{}
+	for rows.Next() {
+		var table, col string
+		if err := rows.Scan(&table, &col); err != nil {
+			t.Fatal(err)
+		}
+		m[table] = append(m[table], col)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	for _, cols := range m {
+		slices.Sort(cols)
+	}
+	return m
+}
+
+// TestSchemaReportsMigration 老库 reports 表上整表的唯一约束要换成只管未处理举报的部分唯一索引，数据原样保留。
+func TestSchemaReportsMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	old, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = old.Exec(`
+		CREATE TABLE reports (
+			id TEXT PRIMARY KEY,
+			target_type TEXT NOT NULL,
+			target_id TEXT NOT NULL,
+			feedback_id TEXT NOT NULL,
+			reporter_user_id TEXT NOT NULL,
+			reason TEXT NOT NULL,
+			detail TEXT NOT NULL DEFAULT '',
+			created_at INTEGER NOT NULL,
+			resolved_at INTEGER,
+			UNIQUE(target_type, target_id, reporter_user_id)
+		);
+		INSERT INTO reports VALUES('r1', 'feedback', 'f1', 'f1', 'u1', 'spam', '', 1, 2);
+	`)
+	_ = old.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := openSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.db.Close()
+	ctx := context.Background()
+
+	insert := `INSERT INTO reports(id, target_type, target_id, feedback_id, reporter_user_id, reason, created_at)
+		VALUES(?, 'feedback', 'f1', 'f1', 'u1', 'spam', 3)
+		ON CONFLICT(target_type, target_id, reporter_user_id) WHERE resolved_at IS NULL DO NOTHING`
+	for _, id := range []string{"r2", "r3"} {
+		if _, err := s.db.ExecContext(ctx, insert, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var n, open int
+	err = s.db.QueryRowContext(ctx, `SELECT COUNT(1), COUNT(1) - COUNT(resolved_at) FROM reports`).Scan(&n, &open)
+	if err != nil || n != 2 || open != 1 {
+		t.Fatalf("reports = %d (open %d), %v; want 2 (open 1)", n, open, err)
+	}
+}
@@ -1,25 +1,74 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"embed"
 	"html/template"
 	"io"
+	"log/slog"
 	"net/http"
 	"path"
 	"strings"
 	"time"
 )
 
-//go:embed web/templates/*.html web/static/*
+//go:embed web/templates/*.html web/static/* web/i18n/*.json
 var webFS embed.FS
 
+// initTemplates 每种语言各解析一份模板，t / date / datetime 这些函数绑定在对应语言上，
+// 模板里不用到处传语言参数。
 func (a *App) initTemplates() {
+	if err := loadCatalogs(); err != nil {
+		fatal("加载语言包失败", err)
+	}
+	a.tpls = map[string]*template.Template{}
+	for _, lang := range supportedLangs {
+		a.tpls[lang] = a.parseTemplates(lang)
+	}
+}
+
+func (a *App) parseTemplates(lang string) *template.Template {
+	var tpl *template.Template
 	funcs := template.FuncMap{
-		"nowYear": func() int { return time.Now().Year() },
-		"md":      renderMarkdown,
+		"nowYear":   func() int { return time.Now().Year() },
+		"md":        a.markdown,
+		"highlight": highlight,
+		// text/template 不支持动态模板名，layout 里用它按 Page 渲染对应的 xxx.content。
+		"content": func(page string, d any) (template.HTML, error) {
+			var buf bytes.Buffer
+			if err := tpl.ExecuteTemplate(&buf, page+".content", d); err != nil {
+				return "", err
+			}
+			return template.HTML(buf.String()), nil
+		},
+		"t":        func(msg string, args ...any) string { return translate(lang, msg, args...) },
+		// 时间相关的函数都要传当前请求的时区（$.TZ）。date / datetime 出纯文本，
+		// ago / timetag / timestamp 出 <time> 元素，分别显示相对时间、到分钟、到秒。
+		"date":     func(loc *time.Location, t time.Time) string { return formatDate(lang, t.In(loc)) },
+		"datetime": func(loc *time.Location, t time.Time) string { return formatDateTime(lang, t.In(loc)) },
+		"iso":      func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+		"tzname":   func(loc *time.Location) string { return tzLabel(loc, time.Now()) },
+		"ago": func(loc *time.Location, t time.Time) template.HTML {
+			abs := formatDateTime(lang, t.In(loc))
+			text := relativeTime(lang, t, time.Now())
+			if text == "" {
+				text = abs
+			}
+			return timeTag(loc, t, abs, text)
+		},
+		"timetag": func(loc *time.Location, t time.Time) template.HTML {
+			abs := formatDateTime(lang, t.In(loc))
+			return timeTag(loc, t, abs, abs)
+		},
+		"timestamp": func(loc *time.Location, t time.Time) template.HTML {
+			abs := t.In(loc).Format("2006-01-02 15:04:05")
+			return timeTag(loc, t, abs, abs)
+		},
 	}
 
-	a.tpl = template.Must(template.New("all").Funcs(funcs).ParseFS(webFS, "web/templates/*.html"))
+	tpl = template.Must(template.New("all").Funcs(funcs).ParseFS(webFS, "web/templates/*.html"))
+	return tpl
 }
 
 type ViewData struct {
@@ -31,6 +80,13 @@ type ViewData struct {
 
 	PublicCount int64
 
+	// 用户主页：AllCount 只给管理员（含私有、待审核、被隐藏的）；PrevPage / NextPage 为 0 表示没有
+	Profile  *User
+	AllCount int64
+	PageNum  int
+	PrevPage int
+	NextPage int
+
 	Query    string
 	Feedback []Feedback
 
@@ -41,14 +97,88 @@ type ViewData struct {
 	CanSee   bool
 
 	FlashError string
+	FlashInfo  string
+
+	Audit        []AuditEntry
+	AuditFilter  AuditFilter
+	AuditActions []string
+
+	BlockedTerms []BlockedTerm
+
+	// 回复模板：管理页是原文，详情页回复框里是已经替换好占位符的；FormStatus 是回复时顺带切换的审核状态
+	ReplyTemplates []ReplyTemplate
+	FormStatus     string
+
+	RequireApproval bool
+
+	Reports       []ReportItem
+	ReportReasons []ReportReason
+
+	// 写反馈表单回填，以及可能重复的已有反馈
+	FormTitle     string
+	FormContent   string
+	FormIsPrivate bool
+	Similar       []Feedback
+	MergedTarget  *Feedback
+
+	// 关注：详情页上当前用户是否已关注；「我的反馈」里关注的反馈和有未读动态的反馈 ID
+	Watching bool
+	Watched  []Feedback
+	Unread   map[string]bool
+
+	// 没有脚本时点「预览」，表单原样带回来，并在下面显示渲染结果
+	Previewing bool
+	Preview    template.HTML
+
+	Import *ImportReport
+
+	// 账号设置页：注销策略、宽限天数、已申请时的执行时间
+	DeletePolicy    string
+	DeleteGraceDays int
+	DeleteDueAt     time.Time
+
+	// 错误页上显示，方便用户反馈问题时对照日志
+	RequestID string
+
+	// 本次响应 CSP 里的 nonce，模板里的 <script> / <style> 要带上 nonce="{{.CSPNonce}}"
+	CSPNonce string
+
+	// 界面语言，以及页脚切换语言后要回到的地址
+	Lang        string
+	CurrentPath string
+
+	// 当前请求的 context，md 解析引用查库时用：{{md $.Ctx .Content}}
+	Ctx context.Context
+
+	// 显示时间用的时区；账号设置页另外带上手动选的时区名和候选列表
+	TZ        *time.Location
+	TZChoice  string
+	Timezones []string
 }
 
 func (a *App) render(w http.ResponseWriter, r *http.Request, page string, d ViewData) {
 	if d.Page == "" {
 		d.Page = strings.TrimSuffix(page, ".html")
 	}
+	if d.RequestID == "" {
+		d.RequestID = requestID(r.Context())
+	}
+	d.CSPNonce = cspNonce(r.Context())
+	d.Ctx = r.Context()
+	d.Lang = requestLang(r)
+	d.TZ = requestLocation(r)
+	d.CurrentPath = r.URL.RequestURI()
+	// 标题和提示是 handler 里写的中文，在这里统一翻译；标题可能是用户写的反馈标题，只做整句匹配。
+	d.Title = translate(d.Lang, d.Title)
+	d.FlashError = translateMessage(d.Lang, d.FlashError)
+	d.FlashInfo = translateMessage(d.Lang, d.FlashInfo)
+
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	_ = a.tpl.ExecuteTemplate(w, page, d)
+	w.Header().Add("Vary", "Accept-Language, Cookie")
+	if err := a.tpls[d.Lang].ExecuteTemplate(w, page, d); err != nil {
+		// 这时响应可能已经写出去一半，没法再换成错误页，只能记下来。
+		slog.ErrorContext(r.Context(), "渲染模板失败", "page", page, "err", err)
+	}
 }
 
 func (a *App) renderError(w http.ResponseWriter, r *http.Request, code int, msg string) {
@@ -60,14 +190,25 @@ func (a *App) renderError(w http.ResponseWriter, r *http.Request, code int, msg
 	})
 }
 
+// serverError 记录内部错误并返回 500。错误细节只进日志，页面上只给 msg 和请求 ID。
+func (a *App) serverError(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	slog.ErrorContext(r.Context(), msg, "method", r.Method, "path", r.URL.Path, "err", err)
+	a.renderError(w, r, http.StatusInternalServerError, msg)
+}
+
 func (a *App) staticHandler() http.Handler {
-	// 轻量静态文件：只提供我们 embed 的 CSS，路径固定 /static/xxx
+	// 轻量静态文件：只提供我们 embed 的 CSS / JS，路径固定 /static/xxx
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		_, file := path.Split(r.URL.Path)
 		if file == "" {
 			http.NotFound(w, r)
 			return
 		}
+		if file == "highlight.css" {
+			w.Header().Set("Content-Type", "text/css; charset=utf-8")
+			_, _ = w.Write(highlightCSS)
+			return
+		}
 		f, err := webFS.Open("web/static/" + file)
 		if err != nil {
 			http.NotFound(w, r)
@@ -75,8 +216,11 @@ func (a *App) staticHandler() http.Handler {
 		}
 		defer f.Close()
 
-		if file == "app.css" {
+		switch path.Ext(file) {
+		case ".css":
 			w.Header().Set("Content-Type", "text/css; charset=utf-8")
+		case ".js":
+			w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
 		}
 		_, _ = io.Copy(w, f)
 	})
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" // 容器镜像里经常没有 /usr/share/zoneinfo
+)
+
+// 时区的来源按优先级：用户在账号设置里选的（tz Cookie）> 浏览器自动上报的（tz_hint Cookie，
+// 由 layout 里的一小段脚本写入）> 服务器本地时区。
+const (
+	tzCookieName     = "tz"
+	tzHintCookieName = "tz_hint"
+)
+
+// commonTimezones 是设置页输入框的候选项，只是方便选择，其他合法的 IANA 名称也可以手填。
+var commonTimezones = []string{
+	"Asia/Shanghai", "Asia/Hong_Kong", "Asia/Taipei", "Asia/Singapore", "Asia/Tokyo", "Asia/Seoul",
+	"Asia/Kolkata", "Asia/Dubai", "Europe/London", "Europe/Berlin", "Europe/Paris", "Europe/Moscow",
+	"America/New_York", "America/Chicago", "America/Denver", "America/Los_Angeles", "America/Sao_Paulo",
+	"Australia/Sydney", "Pacific/Auckland", "UTC",
+}
+
+var tzCache sync.Map // 名称 → *time.Location，LoadLocation 每次都要解析 tzdata
+
+// loadTimezone 按 IANA 名称取时区。名称来自 Cookie，先粗略过滤一下字符再交给标准库。
+func loadTimezone(name string) (*time.Location, bool) {
+	if name == "" || len(name) > 64 || name == "Local" {
+		return nil, false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("/_+-", c)) {
+			return nil, false
+		}
+	}
+	if v, ok := tzCache.Load(name); ok {
+		return v.(*time.Location), true
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, false
+	}
+	tzCache.Store(name, loc)
+	return loc, true
+}
+
+// tzFromCookie 读出 Cookie 里的时区名（浏览器脚本写入时做过 URL 编码）并加载。
+func tzFromCookie(r *http.Request, cookie string) (string, *time.Location, bool) {
+	c, err := r.Cookie(cookie)
+	if err != nil {
+		return "", nil, false
+	}
+	name, err := url.QueryUnescape(c.Value)
+	if err != nil {
+		return "", nil, false
+	}
+	loc, ok := loadTimezone(name)
+	return name, loc, ok
+}
+
+// requestLocation 决定本次请求用哪个时区显示时间。
+func requestLocation(r *http.Request) *time.Location {
+	if _, loc, ok := tzFromCookie(r, tzCookieName); ok {
+		return loc
+	}
+	if _, loc, ok := tzFromCookie(r, tzHintCookieName); ok {
+		return loc
+	}
+	return time.Local
+}
+
+// handleSetTimezone 保存账号设置里选的时区，留空表示跟随浏览器。
+func (a *App) handleSetTimezone(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		a.renderError(w, r, http.StatusBadRequest, "表单解析失败")
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("tz"))
+	c := &http.Cookie{
+		Name:     tzCookieName,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   a.cfg.AppBaseURLHasTLS(),
+	}
+	if name == "" {
+		c.MaxAge = -1
+	} else {
+		if _, ok := loadTimezone(name); !ok {
+			http.Redirect(w, r, "/me/settings?bad_tz=1", http.StatusFound)
+			return
+		}
+		c.Value = url.QueryEscape(name)
+		c.MaxAge = int(365 * 24 * time.Hour / time.Second)
+	}
+	http.SetCookie(w, c)
+	http.Redirect(w, r, "/me/settings", http.StatusFound)
+}
+
+// relativeTime 把过去的时间写成「3 小时前」。超过 30 天、或者是将来的时间，返回空串，由调用方显示绝对时间。
+func relativeTime(lang string, t, now time.Time) string {
+	d := now.Sub(t)
+	var n int
+	var unit string
+	switch {
+	case d < 0 || d >= 30*24*time.Hour:
+		return ""
+	case d < time.Minute:
+		return translate(lang, "刚刚")
+	case d < time.Hour:
+		n, unit = int(d/time.Minute), "分钟"
+	case d < 24*time.Hour:
+		n, unit = int(d/time.Hour), "小时"
+	default:
+		n, unit = int(d/(24*time.Hour)), "天"
+	}
+	// 单数单独一条，英文才能写成 "1 hour ago"。
+	if n == 1 {
+		return translate(lang, "1 "+unit+"前")
+	}
+	return translate(lang, "%d "+unit+"前", n)
+}
+
+// timeTag 输出 <time> 元素：datetime 属性是 RFC 3339，title 是用户时区下的完整时间加时区名，正文是 text。
+func timeTag(loc *time.Location, t time.Time, title, text string) template.HTML {
+	if t.IsZero() {
+		return ""
+	}
+	return template.HTML(fmt.Sprintf(`<time datetime="%s" title="%s">%s</time>`,
+		t.UTC().Format(time.RFC3339),
+		template.HTMLEscapeString(title+" "+tzLabel(loc, t)),
+		template.HTMLEscapeString(text)))
+}
+
+// tzLabel 是 title 里跟在时间后面的时区说明，比如 "Asia/Shanghai"；服务器本地时区没有名字，写成 UTC 偏移。
+func tzLabel(loc *time.Location, t time.Time) string {
+	if loc == time.Local {
+		return t.In(loc).Format("UTC-07:00")
+	}
+	return loc.String()
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// certReloader 持有当前的证书（以及 mTLS 用的客户端 CA），收到 SIGHUP 或发现文件变了就重新加载。
+// 新证书加载失败时继续用旧的，只打日志，不会把服务弄挂。
+type certReloader struct {
+	certFile, keyFile, caFile string
+
+	mu     sync.RWMutex
+	cert   *tls.Certificate
+	caPool *x509.CertPool
+	stamp  string // 三个文件的修改时间，用来判断要不要重新加载
+}
+
+func newCertReloader(certFile, keyFile, caFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certReloader) reload() error {
+	stamp := c.fileStamp()
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("加载证书失败: %w", err)
+	}
+	var pool *x509.CertPool
+	if c.caFile != "" {
+		pem, err := os.ReadFile(c.caFile)
+		if err != nil {
+			return fmt.Errorf("读取客户端 CA 失败: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("客户端 CA 文件里没有可用的证书: %s", c.caFile)
+		}
+	}
+
+	c.mu.Lock()
+	c.cert, c.caPool, c.stamp = &cert, pool, stamp
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *certReloader) fileStamp() string {
+	var s string
+	for _, f := range []string{c.certFile, c.keyFile, c.caFile} {
+		if f == "" {
+			continue
+		}
+		if fi, err := os.Stat(f); err == nil {
+			s += strconv.FormatInt(fi.ModTime().UnixNano(), 10) + "/" + strconv.FormatInt(fi.Size(), 10) + ";"
+		}
+	}
+	return s
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// serverConfig 是主服务用的 TLS 配置。
+func (c *certReloader) serverConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: c.getCertificate,
+	}
+}
+
+// mtlsConfig 要求客户端出示由 caFile 签发的证书。CA 也跟着热加载，所以每次握手现取。
+func (c *certReloader) mtlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: c.getCertificate,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c.mu.RLock()
+			defer c.mu.RUnlock()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*c.cert},
+				ClientCAs:    c.caPool,
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				NextProtos:   []string{"h2", "http/1.1"},
+			}, nil
+		},
+	}
+}
+
+// watch 在收到 hup 或者每隔 interval 发现文件有变化时重新加载。
+// certbot 之类续期证书后不用重启，也不用专门发信号。
+func (c *certReloader) watch(ctx context.Context, hup <-chan os.Signal, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		case <-t.C:
+			c.mu.RLock()
+			same := c.stamp == c.fileStamp()
+			c.mu.RUnlock()
+			if same {
+				continue
+			}
+		}
+		if err := c.reload(); err != nil {
+			slog.Error("重新加载证书失败，继续使用旧证书", "err", err)
+			continue
+		}
+		slog.Info("证书已重新加载", "cert", c.certFile)
+	}
+}
+
+// newRedirectServer 把明文 HTTP 请求 301 到 APP_BASE_URL 上的同一路径。
+// 用配置里的地址而不是请求的 Host，免得被伪造 Host 拿来做开放跳转。
+func newRedirectServer(addr, base string) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		ReadHeaderTimeout: 10 * time.Second,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, base+r.URL.RequestURI(), http.StatusMovedPermanently)
+		}),
+	}
+}
+
+// serve 按是否配置了 TLS 选择 ListenAndServe / ListenAndServeTLS，正常关闭时不算错误。
+func serve(s *http.Server) error {
+	var err error
+	if s.TLSConfig != nil {
+		err = s.ListenAndServeTLS("", "")
+	} else {
+		err = s.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
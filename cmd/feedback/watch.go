@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 关注：登录用户可以关注任何自己看得到的反馈。作者提交时、管理员回复时自动关注。
+// 反馈有新动态（管理员回复、审核结果、被合并）时，除了操作人自己，其他关注者的这条关注标成未读，
+// 在「我的反馈」里显示「有新动态」，打开详情页后清掉。
+
+func (s *sqlStore) Watch(ctx context.Context, feedbackID, userID string) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO watches(feedback_id, user_id, created_at) VALUES(?,?,?)
+		ON CONFLICT(feedback_id, user_id) DO NOTHING
+	`, feedbackID, userID, time.Now().Unix())
+	return err
+}
+
+func (s *sqlStore) Unwatch(ctx context.Context, feedbackID, userID string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM watches WHERE feedback_id = ? AND user_id = ?`, feedbackID, userID)
+	return err
+}
+
+func (s *sqlStore) IsWatching(ctx context.Context, feedbackID, userID string) (bool, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(1) FROM watches WHERE feedback_id = ? AND user_id = ?`, feedbackID, userID).Scan(&n)
+	return n > 0, err
+}
+
+// MarkWatchersUnread 把除 exceptUserID 以外的关注者标成有未读动态。
+func (s *sqlStore) MarkWatchersUnread(ctx context.Context, feedbackID, exceptUserID string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE watches SET unread = 1 WHERE feedback_id = ? AND user_id <> ?`, feedbackID, exceptUserID)
+	return err
+}
+
+func (s *sqlStore) MarkWatchRead(ctx context.Context, feedbackID, userID string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE watches SET unread = 0 WHERE feedback_id = ? AND user_id = ? AND unread = 1`, feedbackID, userID)
+	return err
+}
+
+// UnreadWatches 返回这个用户关注的、有未读动态的反馈 ID。
+func (s *sqlStore) UnreadWatches(ctx context.Context, userID string) (map[string]bool, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT feedback_id FROM watches WHERE user_id = ? AND unread = 1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	m := map[string]bool{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		m[id] = true
+	}
+	return m, rows.Err()
+}
+
+// notifyWatchers 在反馈有新动态时调用，actorUID 是做这次操作的人，不通知自己。
+// 目前只有站内提醒，一条 UPDATE 就够了；以后接邮件等渠道时，再加按反馈列出关注者的查询。
+// 通知失败不影响主流程，只打日志。
+func (a *App) notifyWatchers(ctx context.Context, feedbackID, actorUID, event string) {
+	if err := a.store.MarkWatchersUnread(ctx, feedbackID, actorUID); err != nil {
+		slog.ErrorContext(ctx, "通知关注者失败", "feedback", feedbackID, "event", event, "err", err)
+	}
+}
+
+// autoWatch 是作者提交、管理员回复时的自动关注，失败只打日志。
+func (a *App) autoWatch(ctx context.Context, feedbackID, userID string) {
+	if userID == "" {
+		return
+	}
+	if err := a.store.Watch(ctx, feedbackID, userID); err != nil {
+		slog.ErrorContext(ctx, "自动关注失败", "feedback", feedbackID, "user", userID, "err", err)
+	}
+}
+
+// handleWatch 关注 / 取消关注，表单字段 watch=1 或 0；next 是完成后回到的页面，默认是详情页。
+func (a *App) handleWatch(w http.ResponseWriter, r *http.Request) {
+	sess := a.readSession(r)
+	if sess.UID == "" {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	id := strings.TrimSpace(r.PathValue("id"))
+	if err := r.ParseForm(); err != nil {
+		a.renderError(w, r, http.StatusBadRequest, "表单解析失败")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if a.accountGone(ctx, sess.UID) {
+		a.clearSession(w)
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	item, err := a.store.FeedbackByID(ctx, id)
+	if err != nil || !item.canView(sess) {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.FormValue("watch") == "1" {
+		err = a.store.Watch(ctx, id, sess.UID)
+	} else {
+		err = a.store.Unwatch(ctx, id, sess.UID)
+	}
+	if err != nil {
+		a.serverError(w, r, "写入失败", err)
+		return
+	}
+	back := "/square/" + id
+	if next := r.FormValue("next"); next != "" {
+		back = localRedirect(next)
+	}
+	http.Redirect(w, r, back, http.StatusFound)
+}
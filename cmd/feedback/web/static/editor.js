@@ -0,0 +1,187 @@
+// 写反馈 / 写回复表单的增强：「编辑 / 预览」标签页，以及存在 localStorage 里的草稿。
+// 没有脚本时表单照常可用，预览走表单里的「预览」提交按钮，由服务端重新渲染页面。
+(function () {
+  "use strict";
+
+  var DRAFT_TTL = 30 * 24 * 3600 * 1000; // 超过 30 天的草稿不再恢复
+
+  document.querySelectorAll("form[data-editor]").forEach(setup);
+
+  function setup(form) {
+    var content = form.querySelector("textarea[name=content]");
+    if (!content) return;
+    var title = form.querySelector("input[name=title]");
+    var tabs = form.querySelector("[data-tabs]");
+    var preview = form.querySelector("[data-preview]");
+
+    if (tabs && preview) {
+      setupTabs(form, tabs, content, preview);
+    }
+    setupCanned(form, content);
+    setupDraft(form, "draft:" + form.dataset.draftKey, content, title);
+  }
+
+  // 管理员的回复模板：选中后把（服务端已替换好占位符的）内容插到光标处，
+  // 模板带了审核状态的话顺便选上。没有脚本时走「插入」提交按钮。
+  function setupCanned(form, content) {
+    var select = form.querySelector("select[data-canned]");
+    if (!select) return;
+    var status = form.querySelector("select[data-canned-status]");
+    form.querySelectorAll("[data-canned-submit]").forEach(function (b) {
+      b.hidden = true;
+    });
+
+    var edited = false; // 回复框获得过焦点，才按光标位置插入
+    content.addEventListener("focus", function () {
+      edited = true;
+    });
+
+    select.addEventListener("change", function () {
+      var opt = select.selectedOptions[0];
+      if (!opt || !opt.value) return;
+      var text = opt.dataset.content || "";
+      var start = content.selectionStart;
+      var end = content.selectionEnd;
+      if (!edited) {
+        // 还没动过回复框时接到末尾，和已有内容空一行。
+        start = end = content.value.length;
+      }
+      if (start === content.value.length && content.value.trim()) text = "\n\n" + text;
+      content.value = content.value.slice(0, start) + text + content.value.slice(end);
+      content.setSelectionRange(start + text.length, start + text.length);
+      if (status && opt.dataset.status) status.value = opt.dataset.status;
+      select.value = "";
+      content.focus();
+      content.dispatchEvent(new Event("input", { bubbles: true }));
+    });
+  }
+
+  function setupTabs(form, tabs, content, preview) {
+    tabs.hidden = false;
+    form.querySelectorAll("[data-preview-submit]").forEach(function (b) {
+      b.hidden = true;
+    });
+
+    var seq = 0;
+    tabs.addEventListener("click", function (e) {
+      var tab = e.target.closest("[data-tab]");
+      if (!tab) return;
+      tabs.querySelectorAll("[data-tab]").forEach(function (b) {
+        b.classList.toggle("is-active", b === tab);
+      });
+      if (tab.dataset.tab === "edit") {
+        content.hidden = false;
+        preview.hidden = true;
+        content.focus();
+        return;
+      }
+      content.hidden = true;
+      preview.hidden = false;
+      render();
+    });
+
+    function render() {
+      if (!content.value.trim()) {
+        message(preview.dataset.empty);
+        return;
+      }
+      var mine = ++seq;
+      preview.setAttribute("aria-busy", "true");
+      fetch("/preview", {
+        method: "POST",
+        credentials: "same-origin",
+        body: new URLSearchParams({ content: content.value }),
+      })
+        .then(function (res) {
+          if (!res.ok) throw new Error(res.status);
+          return res.text();
+        })
+        .then(function (html) {
+          // 服务端用的是和正式显示相同的渲染 + sanitizer。
+          if (mine === seq) preview.innerHTML = html;
+        })
+        .catch(function () {
+          if (mine === seq) message(preview.dataset.failed);
+        })
+        .finally(function () {
+          if (mine === seq) preview.removeAttribute("aria-busy");
+        });
+    }
+
+    function message(text) {
+      var p = document.createElement("p");
+      p.className = "muted";
+      p.textContent = text;
+      preview.replaceChildren(p);
+    }
+  }
+
+  function setupDraft(form, key, content, title) {
+    var store = storage();
+    if (!store) return;
+    var note = form.querySelector("[data-draft-note]");
+
+    // 只在表单是空的时候恢复，服务端带回来的内容（预览、相似提示）优先。
+    var saved = load();
+    if (saved && !content.value && !(title && title.value)) {
+      content.value = saved.content || "";
+      if (title) title.value = saved.title || "";
+      if (note) note.hidden = false;
+    }
+
+    var timer;
+    form.addEventListener("input", function () {
+      clearTimeout(timer);
+      timer = setTimeout(save, 400);
+    });
+    form.addEventListener("submit", function (e) {
+      if (e.submitter && (e.submitter.name === "preview" || e.submitter.name === "insert")) {
+        save();
+        return;
+      }
+      clearTimeout(timer);
+      store.removeItem(key);
+    });
+    if (note) {
+      note.querySelector("[data-draft-discard]").addEventListener("click", function () {
+        store.removeItem(key);
+        content.value = "";
+        if (title) title.value = "";
+        note.hidden = true;
+        content.focus();
+      });
+    }
+
+    function save() {
+      var d = { content: content.value, title: title ? title.value : "", at: Date.now() };
+      try {
+        if (!d.content.trim() && !d.title.trim()) {
+          store.removeItem(key);
+        } else {
+          store.setItem(key, JSON.stringify(d));
+        }
+      } catch (e) {
+        // 配额满了或者被禁用，草稿只是锦上添花，忽略。
+      }
+    }
+
+    function load() {
+      try {
+        var d = JSON.parse(store.getItem(key));
+        if (d && Date.now() - d.at < DRAFT_TTL) return d;
+      } catch (e) {}
+      store.removeItem(key);
+      return null;
+    }
+  }
+
+  function storage() {
+    try {
+      var s = window.localStorage;
+      s.getItem("draft:probe");
+      return s;
+    } catch (e) {
+      return null;
+    }
+  }
+})();